@@ -4,13 +4,13 @@ go 1.13
 
 require (
 	github.com/cenkalti/backoff v2.1.1+incompatible // indirect
+	github.com/creack/pty v1.1.11
 	github.com/facebookarchive/pidfile v0.0.0-20150612191647-f242e2999868
 	github.com/facebookgo/atomicfile v0.0.0-20151019160806-2de1f203e7d5 // indirect
 	github.com/facebookgo/pidfile v0.0.0-20150612191647-f242e2999868 // indirect
 	github.com/fukata/golang-stats-api-handler v1.0.0
 	github.com/go-playground/locales v0.11.2 // indirect
 	github.com/go-playground/universal-translator v0.16.0 // indirect
-	github.com/hnakamur/slack-incoming-webhook v0.0.0-20161121081503-0d4239e220a8
 	github.com/jessevdk/go-assets v0.0.0-20160921144138-4f4301a06e15
 	github.com/jessevdk/go-assets-builder v0.0.0-20130903091706-b8483521738f // indirect
 	github.com/jessevdk/go-flags v1.4.0 // indirect
@@ -30,4 +30,5 @@ require (
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/go-playground/validator.v9 v9.9.4
 	gopkg.in/ldap.v2 v2.5.1
+	gopkg.in/yaml.v2 v2.2.8
 )
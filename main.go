@@ -3,14 +3,21 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/edvakf/go-pploy/models/datadog"
 	"github.com/edvakf/go-pploy/models/hook"
 	"github.com/edvakf/go-pploy/models/ldapusers"
 	"github.com/edvakf/go-pploy/models/locks"
+	"github.com/edvakf/go-pploy/models/notify"
+	"github.com/edvakf/go-pploy/models/project"
+	"github.com/edvakf/go-pploy/models/redact"
+	"github.com/edvakf/go-pploy/models/secrets"
 	"github.com/edvakf/go-pploy/models/workdir"
+	"github.com/edvakf/go-pploy/unbuffered"
 	"github.com/edvakf/go-pploy/web"
 	"github.com/facebookarchive/pidfile"
 )
@@ -30,20 +37,46 @@ func init() {
 	var sc hook.SlackConfig
 	var dc datadog.DatadogConfig
 	var lc ldapusers.Config
+	var execMode string
+	var inheritEnv string
+	var logCleanInterval time.Duration
+	var secretKeyFile string
+	var admins string
+	var adminTokenFile string
+	var redactLiterals stringsFlag
+	var redactPatterns stringsFlag
+	var redactDefaults bool
+	var notifyWebhooks stringsFlag
 
 	flag.DurationVar(&lockDuration, "lock", 10*time.Minute, "Duration (ex. 10m) for lock gain")
 	flag.StringVar(&workDir, "workdir", "", "Working directory")
-	flag.IntVar(&workdir.LogMax, "logmax", 20, "Max number of log files to keep")
+	flag.IntVar(&workdir.LogMax, "logmax", 20, "Max number of log files of each kind (deploy or checkout) to keep")
+	flag.DurationVar(&workdir.LogMaxAge, "logmaxage", 0, "Max age (ex. 720h) of log files to keep, 0 means no limit")
+	flag.Int64Var(&workdir.LogMaxBytes, "logmaxbytes", 0, "Max total bytes of log files of all kinds to keep for each project, 0 means no limit")
+	flag.BoolVar(&workdir.LogGzip, "loggzip", false, "Compress previous log files with gzip")
+	flag.DurationVar(&logCleanInterval, "logcleaninterval", 1*time.Hour, "Interval of removing expired log files and log files of removed projects")
+	flag.StringVar(&inheritEnv, "inheritenv", strings.Join(project.InheritedEnv, ","), "Comma separated environment variables passed to checkout and deploy scripts (NAME or PREFIX*)")
+	flag.StringVar(&secretKeyFile, "secretkeyfile", "", "File containing the master key to encrypt secrets with (secrets are disabled when empty)")
+	flag.StringVar(&admins, "admins", "", "Comma separated users who can manage secrets")
+	flag.StringVar(&adminTokenFile, "admintokenfile", "", "File containing the token admins send as a bearer token to manage secrets")
+	flag.Var(&redactLiterals, "redact", "Secret masked in checkout and deploy logs (can be repeated)")
+	flag.Var(&redactPatterns, "redactpattern", "Regexp masked in checkout and deploy logs, only its groups are masked if any (can be repeated)")
+	flag.BoolVar(&redactDefaults, "redactdefaults", true, "Mask AWS keys, bearer tokens and passwords in URLs in checkout and deploy logs")
+	flag.StringVar(&project.LogFormat, "logformat", project.LogFormatText, "Format of deploy log files (text, or json for timestamped lines with a header and a footer)")
+	flag.StringVar(&execMode, "execmode", unbuffered.ModeAuto, "How to run checkout and deploy scripts so that their output is line buffered (auto, stdbuf, pty or none)")
 
 	flag.StringVar(&web.PathPrefix, "prefix", "/", "Path prefix of the app (eg. /pploy/), useful for proxied apps")
 	flag.IntVar(&web.Port, "port", 9000, "HTTP port")
 
 	flag.StringVar(&sc.WebHookURL, "webhook", "", "Incoming web hook URL for slack notification")
+	flag.StringVar(&notify.BaseURL, "baseurl", "", "URL of the app (eg. https://pploy.example.com/pploy/) used for links to logs in notifications")
 	flag.StringVar(&sc.LockGainedMessage, "lockgained", "", "Message template for when lock is gained")
 	flag.StringVar(&sc.LockReleasedMessage, "lockreleased", "", "Message template for when lock is released")
 	flag.StringVar(&sc.LockExtendedMessage, "lockextended", "", "Message template for when lock is extended")
 	flag.StringVar(&sc.DeployedMessage, "deployed", "", "Message template for when deploy is ended")
 
+	flag.Var(&notifyWebhooks, "notifywebhook", "URL to post every lock and deploy event to as JSON (can be repeated)")
+
 	flag.StringVar(&dc.APIKey, "ddapikey", "", "Datadog API key")
 	flag.StringVar(&dc.APPKey, "ddappkey", "", "Datadog APP key")
 	flag.StringVar(&dc.LockGainedMessage, "ddlockgained", "", "Message template for Datadog when lock is gained")
@@ -62,6 +95,53 @@ func init() {
 		log.Fatalf("Please set workdir flag")
 	}
 
+	project.InheritedEnv = splitComma(inheritEnv)
+
+	web.Admins = splitComma(admins)
+	if adminTokenFile != "" {
+		b, err := ioutil.ReadFile(adminTokenFile)
+		if err != nil {
+			log.Fatalf("failed to read admin token file:%s", err.Error())
+		}
+		web.AdminToken = strings.TrimSpace(string(b))
+		if len(web.AdminToken) < 16 {
+			log.Fatalf("admin token must be at least 16 characters")
+		}
+	}
+
+	if secretKeyFile != "" {
+		b, err := ioutil.ReadFile(secretKeyFile)
+		if err != nil {
+			log.Fatalf("failed to read secret key file:%s", err.Error())
+		}
+		key := strings.TrimSpace(string(b))
+		if len(key) < 16 {
+			log.Fatalf("secret key must be at least 16 characters")
+		}
+		secrets.SetMasterKey(key)
+	}
+
+	if project.LogFormat != project.LogFormatText && project.LogFormat != project.LogFormatJSON {
+		log.Fatalf("logformat must be text or json")
+	}
+
+	if redactDefaults {
+		redactPatterns = append(append(stringsFlag{}, redact.DefaultPatterns...), redactPatterns...)
+	}
+	err := redact.Configure(redactLiterals, redactPatterns)
+	if err != nil {
+		log.Fatalf("invalid redactpattern: %s", err.Error())
+	}
+
+	err = unbuffered.SetMode(execMode)
+	if err != nil {
+		log.Fatalf("invalid execmode: %s", err.Error())
+	}
+	if unbuffered.Mode() == unbuffered.ModeNone && execMode == unbuffered.ModeAuto {
+		log.Printf("neither stdbuf nor pty is available, output of scripts may be delayed by buffering")
+	}
+	fmt.Printf("execmode:%s\n", unbuffered.Mode())
+
 	if pidfile.GetPidfilePath() != "" {
 		err := pidfile.Write()
 		if err != nil {
@@ -78,7 +158,38 @@ func init() {
 
 	locks.SetDuration(lockDuration)
 	workdir.Init(workDir)
-	hook.SetSlackConfig(sc)
-	datadog.SetDatadogConfig(dc)
+	workdir.StartLogJanitor(logCleanInterval)
+	if sc.WebHookURL != "" {
+		notify.Register(hook.NewSlack(sc))
+	}
+	if dc.APIKey != "" && dc.APPKey != "" {
+		notify.Register(datadog.NewDatadog(dc))
+	}
+	for _, url := range notifyWebhooks {
+		notify.Register(notify.NewWebhook(url))
+	}
 	ldapusers.SetConfig(lc)
 }
+
+// splitComma splits a comma separated flag value, ignoring spaces and empty items
+func splitComma(s string) []string {
+	a := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			a = append(a, item)
+		}
+	}
+	return a
+}
+
+// stringsFlag is a flag which can be given multiple times
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
@@ -0,0 +1,117 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/edvakf/go-pploy/models/workdir"
+	"github.com/pkg/errors"
+)
+
+// Credential is a per-project secret to access the git remote.
+// SSHKey is used for ssh remotes, Username and Token for https remotes.
+type Credential struct {
+	SSHKey   string
+	Username string
+	Token    string
+}
+
+// Empty returns true when neither an ssh key nor a token is set
+func (c Credential) Empty() bool {
+	return c.SSHKey == "" && c.Token == ""
+}
+
+// askpass prints the username or the token depending on the prompt given by git
+const askpass = `#!/bin/sh
+dir=$(dirname "$0")
+case "$1" in
+Username*) cat "$dir/username" ;;
+*) cat "$dir/token" ;;
+esac
+`
+
+// defaultUsername is used when only a token is given, which works for GitHub and GitLab
+const defaultUsername = "x-access-token"
+
+// Save stores credential of a project, replacing existing one
+func Save(project workdir.Name, c Credential) error {
+	err := Remove(project)
+	if err != nil {
+		return err
+	}
+	if c.Empty() {
+		return nil
+	}
+
+	dir := workdir.CredentialsDir(project)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed to create credentials directory")
+	}
+
+	if c.SSHKey != "" {
+		key := strings.TrimSpace(c.SSHKey) + "\n" // ssh refuses keys without a trailing newline
+		err = ioutil.WriteFile(dir+"/id_deploy", []byte(key), 0600)
+		if err != nil {
+			return errors.Wrap(err, "failed to write ssh key")
+		}
+	}
+	if c.Token != "" {
+		username := c.Username
+		if username == "" {
+			username = defaultUsername
+		}
+		err = ioutil.WriteFile(dir+"/username", []byte(username+"\n"), 0600)
+		if err != nil {
+			return errors.Wrap(err, "failed to write username")
+		}
+		err = ioutil.WriteFile(dir+"/token", []byte(c.Token+"\n"), 0600)
+		if err != nil {
+			return errors.Wrap(err, "failed to write token")
+		}
+		err = ioutil.WriteFile(dir+"/askpass", []byte(askpass), 0700)
+		if err != nil {
+			return errors.Wrap(err, "failed to write askpass script")
+		}
+	}
+	return nil
+}
+
+// Remove deletes credential of a project
+func Remove(project workdir.Name) error {
+	err := os.RemoveAll(workdir.CredentialsDir(project))
+	if err != nil {
+		return errors.Wrap(err, "failed to delete credentials")
+	}
+	return nil
+}
+
+// GitEnv returns environment variables which let git commands use the credential of a project.
+// It returns nothing when the project has no credential, so that git uses the server user's settings.
+func GitEnv(project workdir.Name) []string {
+	dir := workdir.CredentialsDir(project)
+	env := []string{}
+	if fileExists(dir + "/id_deploy") {
+		env = append(env, "GIT_SSH_COMMAND=ssh -i '"+dir+"/id_deploy' -o IdentitiesOnly=yes")
+	}
+	if fileExists(dir + "/askpass") {
+		env = append(env, "GIT_ASKPASS="+dir+"/askpass")
+		env = append(env, "GIT_TERMINAL_PROMPT=0")
+	}
+	return env
+}
+
+// Secrets returns the token of a project to be masked in logs, or nothing when it has no token
+func Secrets(project workdir.Name) []string {
+	b, err := ioutil.ReadFile(workdir.CredentialsDir(project) + "/token")
+	if err != nil {
+		return nil
+	}
+	return []string{strings.TrimSpace(string(b))}
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
@@ -0,0 +1,79 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/edvakf/go-pploy/models/workdir"
+)
+
+func TestSaveAndGitEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workdir.Init(dir)
+
+	if env := GitEnv("foo"); len(env) != 0 {
+		t.Errorf("unexpected env without credential %v", env)
+	}
+
+	err = Save("foo", Credential{SSHKey: "KEY", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(workdir.CredentialsDir("foo") + "/id_deploy")
+	if err != nil || string(b) != "KEY\n" {
+		t.Errorf("unexpected ssh key %q %v", b, err)
+	}
+	env := strings.Join(GitEnv("foo"), "\n")
+	if !strings.Contains(env, "GIT_SSH_COMMAND=ssh -i '"+workdir.CredentialsDir("foo")+"/id_deploy'") ||
+		!strings.Contains(env, "GIT_ASKPASS="+workdir.CredentialsDir("foo")+"/askpass") ||
+		!strings.Contains(env, "GIT_TERMINAL_PROMPT=0") {
+		t.Errorf("unexpected env %s", env)
+	}
+
+	// git runs the askpass script with the prompt
+	askpass := workdir.CredentialsDir("foo") + "/askpass"
+	for prompt, expected := range map[string]string{
+		"Username for 'https://example.com': ": defaultUsername + "\n",
+		"Password for 'https://example.com': ": "secret\n",
+	} {
+		out, err := exec.Command(askpass, prompt).Output()
+		if err != nil || string(out) != expected {
+			t.Errorf("unexpected askpass output %q %v", out, err)
+		}
+	}
+
+	// saving replaces the existing credential
+	err = Save("foo", Credential{Username: "alice", Token: "secret2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env := strings.Join(GitEnv("foo"), "\n"); strings.Contains(env, "GIT_SSH_COMMAND") {
+		t.Errorf("ssh key is not removed %s", env)
+	}
+	out, _ := exec.Command(askpass, "Username: ").Output()
+	if string(out) != "alice\n" {
+		t.Errorf("unexpected username %q", out)
+	}
+	if s := Secrets("foo"); len(s) != 1 || s[0] != "secret2" {
+		t.Errorf("unexpected secrets %v", s)
+	}
+
+	// an empty credential removes it
+	err = Save("foo", Credential{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env := GitEnv("foo"); len(env) != 0 {
+		t.Errorf("credential is not removed %v", env)
+	}
+	if s := Secrets("foo"); len(s) != 0 {
+		t.Errorf("unexpected secrets %v", s)
+	}
+}
@@ -1,11 +1,9 @@
 package datadog
 
 import (
-	"bytes"
 	"crypto/md5"
-	"html/template"
-	"log"
 
+	"github.com/edvakf/go-pploy/models/notify"
 	"github.com/zorkian/go-datadog-api"
 )
 
@@ -19,72 +17,53 @@ type DatadogConfig struct {
 	DeployedMessage     string
 }
 
-var config DatadogConfig
-
-// SetDatadogConfig sets global Datadog config
-func SetDatadogConfig(c DatadogConfig) {
-	config = c
-}
-
-// LockGained sends Datadog when lock is gained
-func LockGained(project, user string) {
-	process(config.LockGainedMessage, project, user, "")
-}
-
-// LockReleased sends Datadog when lock is released
-func LockReleased(project, user string) {
-	process(config.LockReleasedMessage, project, user, "")
-}
-
-// LockExtended sends Datadog when lock is extended
-func LockExtended(project, user string) {
-	process(config.LockExtendedMessage, project, user, "")
+// Datadog posts notifications as Datadog events
+type Datadog struct {
+	config DatadogConfig
+	client *datadog.Client
 }
 
-// Deployed sends Datadog when a user deployed
-func Deployed(project, user, env string) {
-	process(config.DeployedMessage, project, user, env)
+// NewDatadog creates a Datadog notifier
+func NewDatadog(c DatadogConfig) *Datadog {
+	return &Datadog{config: c, client: datadog.NewClient(c.APIKey, c.APPKey)}
 }
 
-func process(message, project, user, env string) {
-	if config.APIKey == "" || config.APPKey == "" || message == "" {
-		return
+// Notify implements the notify.Notifier interface
+func (d *Datadog) Notify(e *notify.Event) error {
+	var message string
+	switch e.Type {
+	case notify.LockGained:
+		message = d.config.LockGainedMessage
+	case notify.LockReleased:
+		message = d.config.LockReleasedMessage
+	case notify.LockExtended:
+		message = d.config.LockExtendedMessage
+	case notify.Deployed:
+		message = d.config.DeployedMessage
+	}
+	if message == "" {
+		return nil
 	}
 
 	eventTag := []string{}
-	eventTag = append(eventTag, "project:"+project)
-	if env != "" {
-		eventTag = append(eventTag, "env:"+env)
+	eventTag = append(eventTag, "project:"+e.Project)
+	if e.Env != "" {
+		eventTag = append(eventTag, "env:"+e.Env)
 	}
 
-	aggregationKey := md5.Sum([]byte(project + user))
+	aggregationKey := md5.Sum([]byte(e.Project + e.User))
 
-	e := datadog.Event{
-		Title:       datadog.String(makeText(message, params{project, user, env})),
+	ev := datadog.Event{
+		Title:       datadog.String(notify.Render(message, e)),
 		Aggregation: datadog.String(string(aggregationKey[:])),
 		SourceType:  datadog.String("pploy"),
 		Tags:        eventTag,
-		Url:         datadog.String("www.pixiv.net"),
 		Resource:    datadog.String("pploy"),
 	}
-
-	client := datadog.NewClient(config.APIKey, config.APPKey)
-	go client.PostEvent(&e)
-}
-
-type params struct {
-	Project string
-	User    string
-	Env     string
-}
-
-func makeText(tmpl string, a interface{}) string {
-	var buf bytes.Buffer
-	tp := template.Must(template.New("calculator").Parse(tmpl))
-	err := tp.Execute(&buf, a)
-	if err != nil {
-		log.Println("failed to process template: " + tmpl)
-		return ""
+	if url := e.LogURL(); url != "" {
+		ev.Url = datadog.String(url)
 	}
-	return buf.String()
+
+	_, err := d.client.PostEvent(&ev)
+	return err
 }
@@ -0,0 +1,53 @@
+package datadog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edvakf/go-pploy/models/notify"
+)
+
+func TestDatadogNotify(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/events" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"event":{}}`))
+	}))
+	defer server.Close()
+
+	notify.BaseURL = "https://pploy.example.com/"
+	defer func() { notify.BaseURL = "" }()
+	d := NewDatadog(DatadogConfig{APIKey: "key", DeployedMessage: "{{.User}} deployed {{.Project}} to {{.Env}}"})
+	d.client.SetBaseUrl(server.URL)
+
+	err := d.Notify(&notify.Event{Type: notify.Deployed, Project: "app", User: "alice", Env: "production", RunID: "20200101-000000-aaaaaa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, _ := received["tags"].([]interface{})
+	if received["title"] != "alice deployed app to production" || received["url"] != "https://pploy.example.com/app/logs/20200101-000000-aaaaaa" || len(tags) != 2 || tags[0] != "project:app" || tags[1] != "env:production" {
+		t.Errorf("unexpected event %v", received)
+	}
+
+	// events without logs have no URL
+	received = nil
+	d.config.LockGainedMessage = "{{.User}} locked {{.Project}}"
+	err = d.Notify(&notify.Event{Type: notify.LockGained, Project: "app", User: "alice"})
+	if _, ok := received["url"]; err != nil || ok {
+		t.Errorf("unexpected event %v", received)
+	}
+	d.config.LockGainedMessage = ""
+
+	// events without messages are not sent
+	received = nil
+	err = d.Notify(&notify.Event{Type: notify.LockGained, Project: "app", User: "alice"})
+	if err != nil || received != nil {
+		t.Errorf("unexpected event %v", received)
+	}
+}
@@ -3,6 +3,7 @@ package gitutil
 import (
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 // Commit is the structured git commit object
 type Commit struct {
 	Hash       string    `json:"hash"`
+	Parents    []string  `json:"parents"`
 	Time       time.Time `json:"time"`
 	Author     string    `json:"author"`
 	OtherRefs  []string  `json:"otherRefs"`
@@ -20,48 +22,118 @@ type Commit struct {
 	NameStatus string    `json:"nameStatus"`
 }
 
+// LogOptions narrows down the commits returned by Log
+type LogOptions struct {
+	Ref    string    // defaults to HEAD
+	Skip   int       // number of commits to skip from the top
+	Limit  int       // defaults to DefaultLogLimit
+	Author string    // pattern passed to --author
+	Path   string    // only commits touching the path
+	Since  time.Time // zero value means no lower bound
+	Until  time.Time // zero value means no upper bound
+}
+
+// DefaultLogLimit is the number of commits returned when LogOptions.Limit is not set
+const DefaultLogLimit = 20
+
+// MaxLogLimit is the maximum number of commits returned at once
+const MaxLogLimit = 100
+
 // RecentCommits runs `git log` and parse the result
 func RecentCommits(dir string) ([]Commit, error) {
+	commits, _, err := Log(dir, LogOptions{})
+	return commits, err
+}
+
+// Log runs `git log` with options and parse the result.
+// It also returns the Skip value for the next page, which is 0 when there are no more commits.
+func Log(dir string, opts LogOptions) ([]Commit, int, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultLogLimit
+	}
+	if limit > MaxLogLimit {
+		limit = MaxLogLimit
+	}
+	ref := opts.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	err := ValidateRef(dir, ref)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	delim1 := "1PPLOY1YOLPP1"
 	delim2 := "2PPLOY2YOLPP2"
-	format := delim1 + strings.Join([]string{"%H", "%ai", "%an", "%d", "%s", "%b", ""}, delim2) // hash, isoLikeDate, author, refs, subject, body, nameStatus
-	cmd := exec.Command(
-		"git",
+	format := delim1 + strings.Join([]string{"%H", "%P", "%ai", "%an", "%d", "%s", "%b", ""}, delim2) // hash, parents, isoLikeDate, author, refs, subject, body, nameStatus
+	args := []string{
 		"log",
 		"-n",
-		"20",              //TODO: make it configurable
+		strconv.Itoa(limit + 1), // one more commit to know whether there is a next page
+		"--skip",
+		strconv.Itoa(opts.Skip),
 		"--decorate=full", // prefix refs with refs/heads/, refs/remotes/origin/ and so on
 		"--name-status",   // show list of file diffs
 		"-m",              // show file diffs for merge commit
 		"--first-parent",  // -m shows file diffs from each parent. --first-parent make it from the first parent
-		"--pretty=format:"+format,
-	)
+		"--pretty=format:" + format,
+	}
+	if opts.Author != "" {
+		args = append(args, "--author="+opts.Author)
+	}
+	if !opts.Since.IsZero() {
+		args = append(args, "--since="+opts.Since.Format(time.RFC3339))
+	}
+	if !opts.Until.IsZero() {
+		args = append(args, "--until="+opts.Until.Format(time.RFC3339))
+	}
+	args = append(args, ref, "--")
+	if opts.Path != "" {
+		args = append(args, opts.Path)
+	}
+	cmd := exec.Command("git", args...)
 	cmd.Dir = dir
 	cmd.Env = os.Environ()
 	// err := cmd.Run()
 	out, err := cmd.Output()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to exec git command")
+		return nil, 0, errors.Wrap(err, "failed to exec git command")
 	}
 
-	chunks := strings.Split(string(out), delim1)
+	commits, err := parseLog(string(out), delim1, delim2)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	next := 0
+	if len(commits) > limit {
+		commits = commits[:limit]
+		next = opts.Skip + limit
+	}
+	return commits, next, nil
+}
+
+func parseLog(out string, delim1 string, delim2 string) ([]Commit, error) {
+	chunks := strings.Split(out, delim1)
 	commits := []Commit{}
 	for _, chunk := range chunks[1:] {
 		parts := strings.Split(chunk, delim2)
 
-		t, err := time.Parse("2006-01-02 15:04:05 -0700", parts[1])
+		t, err := time.Parse("2006-01-02 15:04:05 -0700", parts[2])
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to parse time")
 		}
 
 		commits = append(commits, Commit{
 			Hash:       parts[0],
+			Parents:    strings.Fields(parts[1]),
 			Time:       t,
-			Author:     parts[2],
-			OtherRefs:  parseRefs(parts[3]),
-			Subject:    parts[4],
-			Body:       strings.TrimSpace(parts[5]),
-			NameStatus: strings.TrimSpace(parts[6]),
+			Author:     parts[3],
+			OtherRefs:  parseRefs(parts[4]),
+			Subject:    parts[5],
+			Body:       strings.TrimSpace(parts[6]),
+			NameStatus: strings.TrimSpace(parts[7]),
 		})
 	}
 	return commits, nil
@@ -2,15 +2,17 @@ package gitutil
 
 import (
 	"flag"
+	"os"
 	"testing"
 )
 
 var gitDir string
 
 // go test . -dir=xxx
-func init() {
+func TestMain(m *testing.M) {
 	flag.StringVar(&gitDir, "dir", ".", "a git directory")
 	flag.Parse()
+	os.Exit(m.Run())
 }
 
 func TestRecentCommits(t *testing.T) {
@@ -21,3 +23,24 @@ func TestRecentCommits(t *testing.T) {
 	// fmt.Println(commits[0])
 	t.Logf("%v", commits[0])
 }
+
+func TestLogPagination(t *testing.T) {
+	first, next, err := Log(gitDir, LogOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 {
+		t.Fatalf("expected 1 commit, got %d", len(first))
+	}
+	if next == 0 {
+		t.Skip("repository has only one commit")
+	}
+
+	second, _, err := Log(gitDir, LogOptions{Limit: 1, Skip: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 1 || second[0].Hash == first[0].Hash {
+		t.Errorf("expected the next commit, got %v", second)
+	}
+}
@@ -0,0 +1,150 @@
+package gitutil
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DiffMaxBytes is the maximum size of the whole diff read from git
+var DiffMaxBytes int64 = 1024 * 1024
+
+// DiffMaxFileBytes is the maximum size of the patch of each file
+var DiffMaxFileBytes = 100 * 1024
+
+// Diff is the unified diff of a commit against its first parent
+type Diff struct {
+	Hash      string     `json:"hash"`
+	Parents   []string   `json:"parents"`
+	Files     []FileDiff `json:"files"`
+	Truncated bool       `json:"truncated"` // true when the diff exceeded DiffMaxBytes and files are missing
+}
+
+// FileDiff is the part of a diff for a single file
+type FileDiff struct {
+	Path      string `json:"path"`
+	Patch     string `json:"patch"`
+	Truncated bool   `json:"truncated"` // true when the patch exceeded DiffMaxFileBytes
+}
+
+// CommitDiff returns the diff of a commit. Merge commits are compared to their first parent
+// and root commits to the empty tree.
+func CommitDiff(dir string, commit string) (*Diff, error) {
+	hash, err := ResolveRef(dir, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("git", "show", "-s", "--format=%P", hash)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to exec git command")
+	}
+	parents := strings.Fields(string(out))
+
+	if len(parents) == 0 {
+		cmd = exec.Command("git", "show", "--format=", "--no-color", "--patch", hash)
+	} else {
+		cmd = exec.Command("git", "diff", "--no-color", parents[0], hash)
+	}
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get stdout pipe")
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to exec git command")
+	}
+
+	files, truncated, err := splitDiff(stdout, DiffMaxBytes)
+	if truncated || err != nil {
+		// the rest is not needed, so git is stopped instead of reading a huge diff to the end
+		cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if waitErr != nil && !truncated {
+		return nil, errors.Wrap(waitErr, "failed to exec git command")
+	}
+
+	return &Diff{
+		Hash:      hash,
+		Parents:   parents,
+		Files:     files,
+		Truncated: truncated,
+	}, nil
+}
+
+// splitDiff splits output of `git diff` into files, reading at most max bytes.
+// The second return value is true when there were more than max bytes.
+func splitDiff(r io.Reader, max int64) ([]FileDiff, bool, error) {
+	files := []FileDiff{}
+	var current *FileDiff
+	var patch strings.Builder
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		current.Patch = patch.String()
+		files = append(files, *current)
+		patch.Reset()
+	}
+
+	br := bufio.NewReader(r)
+	var read int64
+	for {
+		line, err := br.ReadString('\n')
+		read += int64(len(line))
+		if read > max {
+			if current != nil {
+				current.Truncated = true
+			}
+			flush()
+			return files, true, nil
+		}
+		if err == io.EOF {
+			if current != nil {
+				patch.WriteString(line)
+			}
+			flush()
+			return files, false, nil
+		}
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to read diff")
+		}
+
+		if strings.HasPrefix(line, "diff --git ") {
+			flush()
+			current = &FileDiff{Path: diffPath(line)}
+		}
+		if current == nil {
+			continue
+		}
+		if patch.Len()+len(line) > DiffMaxFileBytes {
+			current.Truncated = true
+			continue
+		}
+		patch.WriteString(line)
+	}
+}
+
+// diffPath extracts the new path from a line like "diff --git a/foo.go b/foo.go"
+func diffPath(line string) string {
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "diff --git "), "\n")
+	i := strings.LastIndex(line, " b/")
+	if i < 0 {
+		return line
+	}
+	return line[i+len(" b/"):]
+}
@@ -0,0 +1,48 @@
+package gitutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommitDiff(t *testing.T) {
+	diff, err := CommitDiff(gitDir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Hash) != 40 {
+		t.Errorf("expected a full hash, got %q", diff.Hash)
+	}
+	t.Logf("%v", diff.Parents)
+
+	// git is stopped once the limit is hit
+	DiffMaxBytes = 10
+	defer func() { DiffMaxBytes = 1024 * 1024 }()
+	diff, err = CommitDiff(gitDir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Truncated {
+		t.Errorf("expected the diff to be truncated")
+	}
+}
+
+func TestSplitDiff(t *testing.T) {
+	out := "diff --git a/foo b/foo\n+foo\ndiff --git a/bar b/bar\n+bar\n"
+
+	files, truncated, err := splitDiff(strings.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated || len(files) != 2 || files[0].Path != "foo" || files[1].Patch != "diff --git a/bar b/bar\n+bar\n" {
+		t.Errorf("unexpected result %v %v", files, truncated)
+	}
+
+	files, truncated, err = splitDiff(strings.NewReader(out), int64(len(out))-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated || len(files) != 2 || !files[1].Truncated {
+		t.Errorf("expected the second file to be truncated, got %v %v", files, truncated)
+	}
+}
@@ -0,0 +1,140 @@
+package gitutil
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var hashPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+// Ref is a remote branch or a tag with its tip commit
+type Ref struct {
+	Name     string    `json:"name"`
+	FullName string    `json:"fullName"`
+	Type     string    `json:"type"`
+	Hash     string    `json:"hash"`
+	Author   string    `json:"author"`
+	Time     time.Time `json:"time"`
+	Subject  string    `json:"subject"`
+}
+
+// ListRefs runs `git for-each-ref` for remote branches and tags
+// and returns them sorted by the author date of their tip commits, newest first
+func ListRefs(dir string) ([]Ref, error) {
+	delim := "1PPLOY1YOLPP1"
+	// annotated tags point to tag objects, so fields of the dereferenced commit (%(*...)) are also fetched
+	format := strings.Join([]string{
+		"%(refname)", "%(symref)",
+		"%(objectname)", "%(authorname)", "%(authordate:iso)", "%(subject)",
+		"%(*objectname)", "%(*authorname)", "%(*authordate:iso)", "%(*subject)",
+	}, delim)
+	cmd := exec.Command("git", "for-each-ref", "--format="+format, "refs/remotes", "refs/tags")
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to exec git command")
+	}
+
+	refs := []Ref{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, delim)
+		if len(parts) != 10 {
+			return nil, errors.Errorf("unexpected output of git for-each-ref: %q", line)
+		}
+		if parts[1] != "" {
+			continue // skip symbolic refs like refs/remotes/origin/HEAD
+		}
+		fields := parts[2:6]
+		if parts[6] != "" {
+			fields = parts[6:10]
+		}
+
+		t, err := time.Parse("2006-01-02 15:04:05 -0700", fields[2])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse time")
+		}
+
+		ref := Ref{
+			FullName: parts[0],
+			Hash:     fields[0],
+			Author:   fields[1],
+			Time:     t,
+			Subject:  fields[3],
+		}
+		if strings.HasPrefix(ref.FullName, "refs/tags/") {
+			ref.Type = "tag"
+			ref.Name = strings.TrimPrefix(ref.FullName, "refs/tags/")
+		} else {
+			ref.Type = "branch"
+			ref.Name = strings.TrimPrefix(ref.FullName, "refs/remotes/")
+		}
+		refs = append(refs, ref)
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].Time.After(refs[j].Time)
+	})
+	return refs, nil
+}
+
+// Fetch runs `git fetch --prune` with additional environment variables and returns its combined output
+func Fetch(dir string, env []string) (string, error) {
+	cmd := exec.Command("git", "fetch", "--prune")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), errors.Wrap(err, "failed to fetch: "+strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// ResolveRef checks that ref is a well-formed name of an existing commit
+// and returns the full hash of it
+func ResolveRef(dir string, ref string) (string, error) {
+	err := ValidateRef(dir, ref)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Errorf("ref %q does not exist", ref)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ValidateRef rejects anything which is neither an abbreviated or full commit hash
+// nor a ref name allowed by `git check-ref-format`
+func ValidateRef(dir string, ref string) error {
+	if ref == "" {
+		return errors.New("ref is empty")
+	}
+	if strings.HasPrefix(ref, "-") {
+		return errors.Errorf("invalid ref %q", ref)
+	}
+	if hashPattern.MatchString(ref) {
+		return nil
+	}
+
+	cmd := exec.Command("git", "check-ref-format", "--allow-onelevel", ref)
+	cmd.Dir = dir
+	err := cmd.Run()
+	if err != nil {
+		return errors.Errorf("invalid ref %q", ref)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package gitutil
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestResolveRef(t *testing.T) {
+	hash, err := ResolveRef(gitDir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hash) != 40 {
+		t.Errorf("expected a full hash, got %q", hash)
+	}
+
+	for _, ref := range []string{"", "-h", "foo bar", "a..b", "no-such-branch-xyz"} {
+		if _, err := ResolveRef(gitDir, ref); err == nil {
+			t.Errorf("expected error for ref %q", ref)
+		}
+	}
+}
+
+func TestListRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-refs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git := func(date string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=alice", "GIT_AUTHOR_EMAIL=alice@example.com", "GIT_AUTHOR_DATE="+date,
+			"GIT_COMMITTER_NAME=bob", "GIT_COMMITTER_EMAIL=bob@example.com", "GIT_COMMITTER_DATE=2030-01-01T00:00:00Z",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	// committer dates are all the same, so the order is decided by author dates
+	git("2020-01-01T00:00:00Z", "init", "-q")
+	git("2020-01-01T00:00:00Z", "commit", "-q", "--allow-empty", "-m", "first")
+	git("2020-01-01T00:00:00Z", "tag", "-a", "v1", "-m", "v1")
+	git("2021-01-01T00:00:00Z", "commit", "-q", "--allow-empty", "-m", "second")
+	git("2021-01-01T00:00:00Z", "update-ref", "refs/remotes/origin/main", "HEAD")
+
+	refs, err := ListRefs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 {
+		t.Fatalf("unexpected refs %v", refs)
+	}
+	if r := refs[0]; r.Name != "origin/main" || r.Type != "branch" || r.Subject != "second" || r.Author != "alice" || !r.Time.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected ref %+v", r)
+	}
+	if r := refs[1]; r.Name != "v1" || r.Type != "tag" || r.Subject != "first" || !r.Time.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected ref %+v", r)
+	}
+}
+
+func TestValidateRef(t *testing.T) {
+	for ref, valid := range map[string]bool{
+		"main":          true,
+		"feature/x":     true,
+		"0123456789abc": true,
+		"":              false,
+		"-x":            false,
+		"a..b":          false,
+		"foo bar":       false,
+	} {
+		if err := ValidateRef(gitDir, ref); (err == nil) != valid {
+			t.Errorf("unexpected result for %q: %v", ref, err)
+		}
+	}
+}
@@ -2,18 +2,17 @@ package headreader
 
 import (
 	"io"
-	"os"
 )
 
-// New returns a headReader which reads first n bytes of a file
-func New(f *os.File, n int64) io.ReadCloser {
-	r := io.LimitReader(f, n)
-	return &headReader{r, f}
+// New returns a headReader which reads first n bytes of rc
+func New(rc io.ReadCloser, n int64) io.ReadCloser {
+	r := io.LimitReader(rc, n)
+	return &headReader{r, rc}
 }
 
 type headReader struct {
-	r io.Reader
-	f *os.File
+	r  io.Reader
+	rc io.ReadCloser
 }
 
 // Read implements the io.Reader interface
@@ -23,5 +22,5 @@ func (hr *headReader) Read(b []byte) (int, error) {
 
 // Close implements the io.Closer interface
 func (hr *headReader) Close() error {
-	return hr.f.Close()
+	return hr.rc.Close()
 }
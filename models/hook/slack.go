@@ -1,11 +1,11 @@
 package hook
 
 import (
-	"bytes"
-	"html/template"
-	"log"
+	"fmt"
+	"strings"
+	"time"
 
-	slack "github.com/hnakamur/slack-incoming-webhook"
+	"github.com/edvakf/go-pploy/models/notify"
 )
 
 // SlackConfig is a config for slack
@@ -17,58 +17,97 @@ type SlackConfig struct {
 	DeployedMessage     string
 }
 
-var config SlackConfig
-
-// SetSlackConfig sets global slack config
-func SetSlackConfig(c SlackConfig) {
-	config = c
+// Slack sends notifications to a slack incoming web hook
+type Slack struct {
+	config SlackConfig
 }
 
-// LockGained sends hook when lock is gained
-func LockGained(project, user string) {
-	process(config.LockGainedMessage, project, user, "")
+// NewSlack creates a Slack notifier
+func NewSlack(c SlackConfig) *Slack {
+	return &Slack{config: c}
 }
 
-// LockReleased sends hook when lock is released
-func LockReleased(project, user string) {
-	process(config.LockReleasedMessage, project, user, "")
+// Colors of attachments
+const (
+	colorSuccess = "good"
+	colorFailure = "danger"
+)
+
+// Notify implements the notify.Notifier interface.
+// Deploys are sent with an attachment describing the result.
+func (s *Slack) Notify(e *notify.Event) error {
+	var message string
+	switch e.Type {
+	case notify.LockGained:
+		message = s.config.LockGainedMessage
+	case notify.LockReleased:
+		message = s.config.LockReleasedMessage
+	case notify.LockExtended:
+		message = s.config.LockExtendedMessage
+	case notify.Deployed:
+		message = s.config.DeployedMessage
+	}
+	if message == "" {
+		return nil
+	}
+
+	p := payload{Text: notify.Render(message, e)}
+	if e.Type == notify.Deployed {
+		p = deployedPayload(p.Text, e)
+	}
+	return notify.PostJSON(s.config.WebHookURL, p)
 }
 
-// LockExtended sends hook when lock is extended
-func LockExtended(project, user string) {
-	process(config.LockExtendedMessage, project, user, "")
+type payload struct {
+	Text        string       `json:"text"`
+	Channel     string       `json:"channel,omitempty"`
+	Attachments []attachment `json:"attachments,omitempty"`
 }
 
-// Deployed sends hook when a user deployed
-func Deployed(project, user, env string) {
-	process(config.DeployedMessage, project, user, env)
+type attachment struct {
+	Fallback  string   `json:"fallback"`
+	Color     string   `json:"color"`
+	Title     string   `json:"title"`
+	TitleLink string   `json:"title_link,omitempty"`
+	Fields    []field  `json:"fields"`
+	Actions   []action `json:"actions,omitempty"`
+	Ts        int64    `json:"ts"`
 }
 
-func process(message, project, user, env string) {
-	if config.WebHookURL == "" || message == "" {
-		return
-	}
-	go slack.Send(
-		config.WebHookURL,
-		slack.Payload{
-			Text: makeText(message, params{project, user, env}),
-		},
-	)
+type field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
 }
 
-type params struct {
-	Project string
-	User    string
-	Env     string
+type action struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	URL  string `json:"url"`
 }
 
-func makeText(tmpl string, a interface{}) string {
-	var buf bytes.Buffer
-	tp := template.Must(template.New("calculator").Parse(tmpl))
-	err := tp.Execute(&buf, a)
-	if err != nil {
-		log.Println("failed to process template: " + tmpl)
-		return ""
+func deployedPayload(text string, e *notify.Event) payload {
+	a := attachment{
+		Fallback:  text,
+		Color:     colorSuccess,
+		Title:     fmt.Sprintf("%s deployed to %s", e.Project, e.Env),
+		TitleLink: e.LogURL(),
+		Fields: []field{
+			{Title: "Project", Value: e.Project, Short: true},
+			{Title: "Env", Value: e.Env, Short: true},
+			{Title: "User", Value: e.User, Short: true},
+			{Title: "Duration", Value: e.Duration.Round(100 * time.Millisecond).String(), Short: true},
+			{Title: "Commit", Value: strings.TrimSpace(e.ShortCommit() + " " + e.Subject)},
+		},
+		Ts: time.Now().Unix(),
 	}
-	return buf.String()
+	if !e.Success() {
+		a.Color = colorFailure
+		a.Title = fmt.Sprintf("%s failed to deploy to %s (exit status %d)", e.Project, e.Env, e.ExitStatus)
+	}
+	if a.TitleLink != "" {
+		a.Actions = []action{{Type: "button", Text: "View log", URL: a.TitleLink}}
+	}
+
+	return payload{Text: text, Channel: e.Options["slackChannel"], Attachments: []attachment{a}}
 }
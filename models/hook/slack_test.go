@@ -0,0 +1,65 @@
+package hook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edvakf/go-pploy/models/notify"
+)
+
+func TestSlackDeployed(t *testing.T) {
+	var received payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	notify.BaseURL = "https://pploy.example.com/pploy/"
+	defer func() { notify.BaseURL = "" }()
+	s := NewSlack(SlackConfig{
+		WebHookURL:      server.URL,
+		DeployedMessage: "{{.User}} deployed {{.ShortCommit}} {{.Subject}} to {{.Env}} in {{.Duration}}",
+	})
+
+	e := &notify.Event{
+		Type:       notify.Deployed,
+		Project:    "app",
+		User:       "alice",
+		Env:        "production",
+		RunID:      "20200101-000000-aaaaaa",
+		Commit:     "0123456789abcdef",
+		Subject:    "Fix typo",
+		ExitStatus: 0,
+		Duration:   12345 * time.Millisecond,
+		Options:    map[string]string{"slackChannel": "#deploy"},
+	}
+	err := s.Notify(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Text != "alice deployed 0123456 Fix typo to production in 12.3s" || received.Channel != "#deploy" || len(received.Attachments) != 1 {
+		t.Fatalf("unexpected payload %+v", received)
+	}
+	a := received.Attachments[0]
+	if a.Color != colorSuccess || a.TitleLink != "https://pploy.example.com/pploy/app/logs/20200101-000000-aaaaaa" || a.Actions[0].URL != a.TitleLink {
+		t.Errorf("unexpected attachment %+v", a)
+	}
+	if f := a.Fields[len(a.Fields)-1]; f.Value != "0123456 Fix typo" {
+		t.Errorf("unexpected field %+v", f)
+	}
+
+	e.ExitStatus = 2
+	if a := deployedPayload("", e).Attachments[0]; a.Color != colorFailure {
+		t.Errorf("unexpected attachment %+v", a)
+	}
+
+	// events without messages are not sent
+	received = payload{}
+	err = s.Notify(&notify.Event{Type: notify.LockGained, Project: "app", User: "alice"})
+	if err != nil || received.Text != "" {
+		t.Errorf("unexpected payload %+v", received)
+	}
+}
@@ -0,0 +1,169 @@
+package jobs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+)
+
+// Job statuses
+const (
+	Running   = "running"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+// how long finished jobs are kept in memory
+var retention = 1 * time.Hour
+
+// Info is the status of a job
+type Info struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Project    string     `json:"project"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
+
+// Job is a task running in background whose output can be followed while running
+type Job struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	info   Info
+	output []byte
+}
+
+// map of job ID to job
+var jobs = make(map[string]*Job)
+
+var mu sync.Mutex
+
+// Start runs fn in a goroutine as a job. Output written by fn to w is kept in the job,
+// and the error returned by fn marks the job as failed.
+func Start(kind string, project string, fn func(w io.Writer) error) *Job {
+	j := &Job{
+		info: Info{
+			ID:        newID(),
+			Kind:      kind,
+			Project:   project,
+			Status:    Running,
+			StartedAt: time.Now(),
+		},
+	}
+	j.cond = sync.NewCond(&j.mu)
+
+	mu.Lock()
+	prune(time.Now())
+	jobs[j.info.ID] = j
+	mu.Unlock()
+
+	go func() {
+		err := fn(j)
+		j.finish(err)
+	}()
+	return j
+}
+
+// Get returns a job by its ID, or nil when not found
+func Get(id string) *Job {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return jobs[id]
+}
+
+// IsRunning returns true when a job of the kind for the project is running
+func IsRunning(kind string, project string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, j := range jobs {
+		info := j.Info()
+		if info.Kind == kind && info.Project == project && info.Status == Running {
+			return true
+		}
+	}
+	return false
+}
+
+// Info returns a snapshot of the job status
+func (j *Job) Info() Info {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.info
+}
+
+// Write implements the io.Writer interface.
+// Carriage returns used by progress output are turned into newlines, so that each update becomes a line.
+func (j *Job) Write(b []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.output = append(j.output, bytes.Replace(b, []byte("\r"), []byte("\n"), -1)...)
+	j.cond.Broadcast()
+	return len(b), nil
+}
+
+// NewReader returns a Reader of the job output from the beginning,
+// which blocks for more output until the job finishes
+func (j *Job) NewReader() io.Reader {
+	return &reader{j: j}
+}
+
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	j.info.FinishedAt = &now
+	if err != nil {
+		j.info.Status = Failed
+		j.info.Error = err.Error()
+	} else {
+		j.info.Status = Succeeded
+	}
+	j.cond.Broadcast()
+}
+
+type reader struct {
+	j      *Job
+	offset int
+}
+
+// Read implements the io.Reader interface
+func (r *reader) Read(b []byte) (int, error) {
+	r.j.mu.Lock()
+	defer r.j.mu.Unlock()
+
+	for r.offset >= len(r.j.output) && r.j.info.Status == Running {
+		r.j.cond.Wait()
+	}
+	if r.offset >= len(r.j.output) {
+		return 0, io.EOF
+	}
+	n := copy(b, r.j.output[r.offset:])
+	r.offset += n
+	return n, nil
+}
+
+// prune forgets jobs which finished long ago. mu must be held.
+func prune(now time.Time) {
+	for id, j := range jobs {
+		info := j.Info()
+		if info.FinishedAt != nil && now.Sub(*info.FinishedAt) > retention {
+			delete(jobs, id)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,33 @@
+package jobs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestJob(t *testing.T) {
+	release := make(chan struct{})
+	j := Start("test", "foo", func(w io.Writer) error {
+		io.WriteString(w, "10%\r20%\r")
+		<-release
+		io.WriteString(w, "done\n")
+		return errors.New("oops")
+	})
+
+	r := j.NewReader()
+	close(release)
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "10%\n20%\ndone\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	info := Get(j.Info().ID).Info()
+	if info.Status != Failed || info.Error != "oops" {
+		t.Errorf("unexpected info %v", info)
+	}
+}
@@ -5,8 +5,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/edvakf/go-pploy/models/datadog"
-	"github.com/edvakf/go-pploy/models/hook"
+	"github.com/edvakf/go-pploy/models/notify"
 )
 
 // Lock is project's deployment lock
@@ -55,8 +54,7 @@ func Gain(project string, user string, now time.Time) (*Lock, error) {
 	}
 	l = Lock{User: user, EndTime: now.Add(lockDuration)}
 	locks[project] = l
-	datadog.LockGained(project, user)
-	hook.LockGained(project, user)
+	notify.Send(&notify.Event{Type: notify.LockGained, Project: project, User: user})
 	return &l, nil
 }
 
@@ -73,8 +71,7 @@ func Extend(project string, user string, now time.Time) (*Lock, error) {
 	// l.EndTime = l.EndTime.Add(lockDuration)
 	l = Lock{User: user, EndTime: l.EndTime.Add(lockDuration)}
 	locks[project] = l
-	datadog.LockExtended(project, user)
-	hook.LockExtended(project, user)
+	notify.Send(&notify.Event{Type: notify.LockExtended, Project: project, User: user})
 	return &l, nil
 }
 
@@ -89,8 +86,7 @@ func Release(project string, user string, now time.Time) error {
 		return errors.New("user does not have lock for the project")
 	}
 	delete(locks, project)
-	datadog.LockReleased(project, user)
-	hook.LockReleased(project, user)
+	notify.Send(&notify.Event{Type: notify.LockReleased, Project: project, User: user})
 	return nil
 }
 
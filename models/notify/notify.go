@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Event types
+const (
+	LockGained   = "lock_gained"
+	LockReleased = "lock_released"
+	LockExtended = "lock_extended"
+	Deployed     = "deployed"
+)
+
+// Event is what happened to a project, which is sent to all registered notifiers
+type Event struct {
+	Type    string
+	Project string
+	User    string
+
+	// deployed
+	Env        string
+	RunID      string
+	Commit     string
+	Subject    string // subject of the commit
+	ExitStatus int
+	Duration   time.Duration
+	Options    map[string]string // options of notification backends from the project config, like slackChannel
+}
+
+// Notifier is a backend which sends notifications of events
+type Notifier interface {
+	Notify(e *Event) error
+}
+
+// BaseURL is the URL of the app used for links to logs
+var BaseURL string
+
+var notifiers []Notifier
+
+var mu sync.Mutex
+
+// Register adds a notifier which receives all events sent after that
+func Register(n Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	notifiers = append(notifiers, n)
+}
+
+// Send notifies an event to all registered notifiers in background
+func Send(e *Event) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, n := range notifiers {
+		go func(n Notifier) {
+			err := n.Notify(e)
+			if err != nil {
+				log.Printf("failed to send %s notification: %s", e.Type, err.Error())
+			}
+		}(n)
+	}
+}
+
+// LogURL returns the permalink of the log of the deploy, or an empty string when BaseURL is not set
+func (e *Event) LogURL() string {
+	if BaseURL == "" || e.RunID == "" {
+		return ""
+	}
+	return strings.TrimSuffix(BaseURL, "/") + "/" + e.Project + "/logs/" + e.RunID
+}
+
+// ShortCommit returns the first 7 characters of the commit hash
+func (e *Event) ShortCommit() string {
+	if len(e.Commit) > 7 {
+		return e.Commit[:7]
+	}
+	return e.Commit
+}
+
+// Success returns true when the deploy succeeded
+func (e *Event) Success() bool {
+	return e.ExitStatus == 0
+}
+
+// params are the fields available in message templates
+type params struct {
+	Project     string
+	User        string
+	Env         string
+	RunID       string
+	Commit      string
+	ShortCommit string
+	Subject     string
+	ExitStatus  int
+	Success     bool
+	Duration    string // like 1m2.3s
+	LogURL      string
+}
+
+// Render executes a message template with the fields of an event. Fields other than Project and User are set only for deploys.
+func Render(tmpl string, e *Event) string {
+	p := params{
+		Project:     e.Project,
+		User:        e.User,
+		Env:         e.Env,
+		RunID:       e.RunID,
+		Commit:      e.Commit,
+		ShortCommit: e.ShortCommit(),
+		Subject:     e.Subject,
+		ExitStatus:  e.ExitStatus,
+		Success:     e.Success(),
+		Duration:    e.Duration.Round(100 * time.Millisecond).String(),
+		LogURL:      e.LogURL(),
+	}
+
+	var buf bytes.Buffer
+	tp, err := template.New("message").Parse(tmpl)
+	if err == nil {
+		err = tp.Execute(&buf, p)
+	}
+	if err != nil {
+		log.Println("failed to process template: " + tmpl)
+		return ""
+	}
+	return buf.String()
+}
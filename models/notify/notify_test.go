@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type chanNotifier chan *Event
+
+func (c chanNotifier) Notify(e *Event) error {
+	c <- e
+	return nil
+}
+
+func TestSend(t *testing.T) {
+	c := make(chanNotifier, 1)
+	Register(c)
+	defer func() { notifiers = nil }()
+
+	Send(&Event{Type: LockGained, Project: "app", User: "alice"})
+	select {
+	case e := <-c:
+		if e.Type != LockGained || e.Project != "app" {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event is not notified")
+	}
+}
+
+func TestRender(t *testing.T) {
+	e := &Event{Type: Deployed, Project: "app", User: "alice", Env: "production", Commit: "0123456789", ExitStatus: 1}
+	s := Render("{{.User}} {{.Env}} {{.ShortCommit}} {{if .Success}}ok{{else}}failed {{.ExitStatus}}{{end}}", e)
+	if s != "alice production 0123456 failed 1" {
+		t.Errorf("unexpected message %q", s)
+	}
+	if s := Render("{{.Unknown}}", e); s != "" {
+		t.Errorf("unexpected message %q", s)
+	}
+
+	// messages are plain text, not escaped for HTML
+	e.Subject = "Don't break <b> & fix"
+	if s := Render("{{.Subject}}", e); s != e.Subject {
+		t.Errorf("unexpected message %q", s)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	err := NewWebhook(server.URL).Notify(&Event{Type: Deployed, Project: "app", User: "alice", Env: "staging", Duration: 1500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received["type"] != Deployed || received["env"] != "staging" || received["exitStatus"] != 0.0 || received["duration"] != 1.5 {
+		t.Errorf("unexpected payload %v", received)
+	}
+
+	received = nil
+	err = NewWebhook(server.URL).Notify(&Event{Type: LockReleased, Project: "app", User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := received["exitStatus"]; ok || received["type"] != LockReleased {
+		t.Errorf("unexpected payload %v", received)
+	}
+}
+
+func TestPostJSONTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	timeout := httpClient.Timeout
+	httpClient.Timeout = 100 * time.Millisecond
+	defer func() { httpClient.Timeout = timeout }()
+
+	if err := PostJSON(server.URL, struct{}{}); err == nil {
+		t.Error("hanging endpoint does not time out")
+	}
+}
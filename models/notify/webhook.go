@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook posts every event as JSON to a URL
+type Webhook struct {
+	URL string
+}
+
+// NewWebhook creates a Webhook
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url}
+}
+
+// webhookPayload is the JSON body posted by Webhook
+type webhookPayload struct {
+	Type       string   `json:"type"`
+	Project    string   `json:"project"`
+	User       string   `json:"user"`
+	Env        string   `json:"env,omitempty"`
+	RunID      string   `json:"runId,omitempty"`
+	Commit     string   `json:"commit,omitempty"`
+	Subject    string   `json:"subject,omitempty"`
+	ExitStatus *int     `json:"exitStatus,omitempty"`
+	Duration   *float64 `json:"duration,omitempty"` // seconds
+	LogURL     string   `json:"logUrl,omitempty"`
+}
+
+// Notify implements the Notifier interface
+func (w *Webhook) Notify(e *Event) error {
+	p := webhookPayload{
+		Type:    e.Type,
+		Project: e.Project,
+		User:    e.User,
+	}
+	if e.Type == Deployed {
+		duration := e.Duration.Seconds()
+		p.Env, p.RunID, p.Commit, p.Subject = e.Env, e.RunID, e.Commit, e.Subject
+		p.ExitStatus, p.Duration = &e.ExitStatus, &duration
+		p.LogURL = e.LogURL()
+	}
+	return PostJSON(w.URL, p)
+}
+
+// httpClient times out, so that a hanging endpoint does not leave goroutines sending notifications forever
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// PostJSON posts v as JSON to a URL, and fails unless the response status is 2xx.
+// The Slack backend and webhooks share it.
+func PostJSON(url string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("bad response status: %d, resp=%s", resp.StatusCode, string(body))
+	}
+	return nil
+}
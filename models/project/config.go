@@ -0,0 +1,343 @@
+package project
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edvakf/go-pploy/models/workdir"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigFile is the path of the optional project config file relative to the project directory
+const ConfigFile = ".deploy/config/pploy.yaml"
+
+// Config is the project config read from ConfigFile.
+// Projects without the file get a config built from deploy_envs.
+type Config struct {
+	Envs          []EnvConfig         `yaml:"envs" json:"envs"`
+	Scripts       ScriptsConfig       `yaml:"scripts" json:"scripts"`
+	Timeout       time.Duration       `yaml:"timeout" json:"timeout"`
+	Limits        Limits              `yaml:"limits" json:"limits"`
+	Interactive   bool                `yaml:"interactive" json:"interactive"` // deploy scripts can ask the lock holder
+	Env           map[string]string   `yaml:"env" json:"-"`
+	Notifications NotificationsConfig `yaml:"notifications" json:"notifications"`
+	Params        []ParamConfig       `yaml:"params" json:"params"`
+}
+
+// EnvConfig is the config of a deploy environment
+type EnvConfig struct {
+	Name          string              `yaml:"name" json:"name"`
+	DisplayName   string              `yaml:"displayName" json:"displayName"`
+	Color         string              `yaml:"color" json:"color"`
+	Lock          LockRule            `yaml:"lock" json:"lock"`
+	Timeout       time.Duration       `yaml:"timeout" json:"timeout"`         // overrides Config.Timeout
+	Limits        Limits              `yaml:"limits" json:"limits"`           // overrides Config.Limits for each non-zero field
+	Interactive   bool                `yaml:"interactive" json:"interactive"` // enables Config.Interactive for the environment
+	Env           map[string]string   `yaml:"env" json:"-"`                   // added to Config.Env
+	Notifications NotificationsConfig `yaml:"notifications" json:"notifications"`
+	Params        []ParamConfig       `yaml:"params" json:"params"` // added to Config.Params
+}
+
+// LockRule restricts who can deploy to an environment
+type LockRule struct {
+	Required bool     `yaml:"required" json:"required"` // deploying requires holding the project lock
+	Users    []string `yaml:"users" json:"users"`       // only these users can deploy when not empty
+}
+
+// ScriptsConfig overrides paths of the scripts relative to the project directory
+type ScriptsConfig struct {
+	Deploy     string `yaml:"deploy" json:"deploy"`
+	PreDeploy  string `yaml:"preDeploy" json:"preDeploy"`
+	PostDeploy string `yaml:"postDeploy" json:"postDeploy"`
+	OnFailure  string `yaml:"onFailure" json:"onFailure"`
+	Checkout   string `yaml:"checkout" json:"checkout"`
+}
+
+// NotificationsConfig are options passed to notification backends with deploy events, like slackChannel
+type NotificationsConfig map[string]string
+
+var colorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[a-zA-Z]+)$`)
+
+var envVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// readConfig reads ConfigFile of a project, falling back to deploy_envs when it does not exist
+func readConfig(name workdir.Name) (*Config, error) {
+	file := workdir.ProjectDir(name) + "/" + ConfigFile
+	if !fileExists(file) {
+		envs, err := readDeployEnvs(name)
+		if err != nil {
+			return nil, err
+		}
+		c := &Config{}
+		for _, env := range envs {
+			c.Envs = append(c.Envs, EnvConfig{Name: env})
+		}
+		c.setDefaults()
+		return c, nil
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading file")
+	}
+	c, err := parseConfig(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid "+ConfigFile)
+	}
+	if len(c.Envs) == 0 {
+		envs, err := readDeployEnvs(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, env := range envs {
+			c.Envs = append(c.Envs, EnvConfig{Name: env})
+		}
+	}
+	c.setDefaults()
+	return c, nil
+}
+
+func parseConfig(b []byte) (*Config, error) {
+	c := &Config{}
+	err := yaml.UnmarshalStrict(b, c)
+	if err != nil {
+		return nil, err
+	}
+	err = c.validate()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	if c.Timeout < 0 {
+		return errors.New("timeout: must not be negative")
+	}
+	if c.Limits.CPUTime < 0 {
+		return errors.New("limits.cpuTime: must not be negative")
+	}
+	if err := validateEnvVars(c.Env); err != nil {
+		return errors.Wrap(err, "env")
+	}
+	if err := validateScriptPath(c.Scripts.Deploy); err != nil {
+		return errors.Wrap(err, "scripts.deploy")
+	}
+	if err := validateScriptPath(c.Scripts.PreDeploy); err != nil {
+		return errors.Wrap(err, "scripts.preDeploy")
+	}
+	if err := validateScriptPath(c.Scripts.PostDeploy); err != nil {
+		return errors.Wrap(err, "scripts.postDeploy")
+	}
+	if err := validateScriptPath(c.Scripts.OnFailure); err != nil {
+		return errors.Wrap(err, "scripts.onFailure")
+	}
+	if err := validateScriptPath(c.Scripts.Checkout); err != nil {
+		return errors.Wrap(err, "scripts.checkout")
+	}
+	if err := validateParams(c.Params); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for i, env := range c.Envs {
+		prefix := fmt.Sprintf("envs[%d]", i)
+		if env.Name == "" {
+			return errors.New(prefix + ": name is required")
+		}
+		if strings.ContainsAny(env.Name, " \t\n") {
+			return errors.Errorf("%s: name %q must not contain spaces", prefix, env.Name)
+		}
+		if seen[env.Name] {
+			return errors.Errorf("%s: duplicate name %q", prefix, env.Name)
+		}
+		seen[env.Name] = true
+		if env.Color != "" && !colorPattern.MatchString(env.Color) {
+			return errors.Errorf("%s: invalid color %q (use #rgb, #rrggbb or a color name)", prefix, env.Color)
+		}
+		if env.Timeout < 0 {
+			return errors.New(prefix + ": timeout must not be negative")
+		}
+		if env.Limits.CPUTime < 0 {
+			return errors.New(prefix + ": limits.cpuTime must not be negative")
+		}
+		if err := validateEnvVars(env.Env); err != nil {
+			return errors.Wrap(err, prefix+": env")
+		}
+		if err := validateParams(env.Params); err != nil {
+			return errors.Wrap(err, prefix)
+		}
+	}
+	return nil
+}
+
+func validateEnvVars(env map[string]string) error {
+	for k := range env {
+		if !envVarPattern.MatchString(k) {
+			return errors.Errorf("invalid variable name %q", k)
+		}
+		if strings.HasPrefix(k, "DEPLOY_") {
+			return errors.Errorf("%s: DEPLOY_ variables are reserved", k)
+		}
+	}
+	return nil
+}
+
+// validateScriptPath rejects paths pointing outside of the project directory
+func validateScriptPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return errors.Errorf("%q must be a relative path inside the project", path)
+	}
+	return nil
+}
+
+func (c *Config) setDefaults() {
+	if c.Scripts.Deploy == "" {
+		c.Scripts.Deploy = ".deploy/bin/deploy"
+	}
+	if c.Scripts.PreDeploy == "" {
+		c.Scripts.PreDeploy = ".deploy/bin/pre_deploy"
+	}
+	if c.Scripts.PostDeploy == "" {
+		c.Scripts.PostDeploy = ".deploy/bin/post_deploy"
+	}
+	if c.Scripts.OnFailure == "" {
+		c.Scripts.OnFailure = ".deploy/bin/on_failure"
+	}
+	if c.Scripts.Checkout == "" {
+		c.Scripts.Checkout = ".deploy/bin/checkout_overwrite"
+	}
+	for i := range c.Envs {
+		env := &c.Envs[i]
+		if env.DisplayName == "" {
+			env.DisplayName = env.Name
+		}
+		env.Params = mergeParams(c.Params, env.Params)
+		for j := range env.Params {
+			if env.Params[j].Type == "" {
+				env.Params[j].Type = ParamText
+			}
+			if env.Params[j].Label == "" {
+				env.Params[j].Label = env.Params[j].Name
+			}
+		}
+	}
+}
+
+// EnvNames returns names of the deploy environments
+func (c *Config) EnvNames() []string {
+	names := []string{}
+	for _, env := range c.Envs {
+		names = append(names, env.Name)
+	}
+	return names
+}
+
+// FindEnv returns the config of a deploy environment, or nil when not found
+func (c *Config) FindEnv(name string) *EnvConfig {
+	for i := range c.Envs {
+		if c.Envs[i].Name == name {
+			return &c.Envs[i]
+		}
+	}
+	return nil
+}
+
+// EnvVars returns environment variables of the project and a deploy environment
+// in the form of "KEY=value" sorted by key
+func (c *Config) EnvVars(env *EnvConfig) []string {
+	vars := map[string]string{}
+	for k, v := range c.Env {
+		vars[k] = v
+	}
+	if env != nil {
+		for k, v := range env.Env {
+			vars[k] = v
+		}
+	}
+
+	keys := []string{}
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	a := []string{}
+	for _, k := range keys {
+		a = append(a, k+"="+vars[k])
+	}
+	return a
+}
+
+// TimeoutFor returns the maximum runtime of a deploy to the environment, 0 meaning no limit
+func (c *Config) TimeoutFor(env *EnvConfig) time.Duration {
+	if env != nil && env.Timeout > 0 {
+		return env.Timeout
+	}
+	return c.Timeout
+}
+
+// NotificationsFor returns options of notification backends for a deploy to the environment,
+// where options of the environment override those of the project
+func (c *Config) NotificationsFor(env *EnvConfig) NotificationsConfig {
+	options := NotificationsConfig{}
+	for k, v := range c.Notifications {
+		options[k] = v
+	}
+	if env != nil {
+		for k, v := range env.Notifications {
+			options[k] = v
+		}
+	}
+	return options
+}
+
+// InteractiveFor returns true when deploy scripts to the environment read answers of prompts from stdin.
+// Otherwise their stdin is /dev/null, so that scripts reading it do not hang.
+func (c *Config) InteractiveFor(env *EnvConfig) bool {
+	return c.Interactive || (env != nil && env.Interactive)
+}
+
+// LimitsFor returns resource limits of a deploy to the environment
+func (c *Config) LimitsFor(env *EnvConfig) Limits {
+	l := c.Limits
+	if env == nil {
+		return l
+	}
+	if env.Limits.CPUTime > 0 {
+		l.CPUTime = env.Limits.CPUTime
+	}
+	if env.Limits.MemoryMB > 0 {
+		l.MemoryMB = env.Limits.MemoryMB
+	}
+	if env.Limits.OpenFiles > 0 {
+		l.OpenFiles = env.Limits.OpenFiles
+	}
+	return l
+}
+
+// CanDeploy checks lock rules of a deploy environment
+func (env *EnvConfig) CanDeploy(user string, lockUser string) error {
+	if env.Lock.Required && lockUser != user {
+		return errors.Errorf("deploying to %s requires holding the lock", env.Name)
+	}
+	if len(env.Lock.Users) == 0 {
+		return nil
+	}
+	for _, u := range env.Lock.Users {
+		if u == user {
+			return nil
+		}
+	}
+	return errors.Errorf("%s is not allowed to deploy to %s", user, env.Name)
+}
@@ -0,0 +1,121 @@
+package project
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := parseConfig([]byte(`
+envs:
+  - name: staging
+    color: "#28a745"
+  - name: production
+    displayName: Production
+    lock:
+      required: true
+      users: [alice]
+    timeout: 30m
+    env:
+      REPLICAS: "3"
+    notifications:
+      slackChannel: "#deploy-production"
+timeout: 10m
+env:
+  APP: foo
+  REPLICAS: "1"
+notifications:
+  slackChannel: "#deploy"
+  mention: "@here"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.setDefaults()
+
+	if strings.Join(c.EnvNames(), ",") != "staging,production" {
+		t.Errorf("unexpected envs %v", c.EnvNames())
+	}
+	prod := c.FindEnv("production")
+	if strings.Join(c.EnvVars(prod), " ") != "APP=foo REPLICAS=3" {
+		t.Errorf("unexpected env vars %v", c.EnvVars(prod))
+	}
+	if n := c.NotificationsFor(prod); len(n) != 2 || n["slackChannel"] != "#deploy-production" || n["mention"] != "@here" {
+		t.Errorf("unexpected notification options %v", n)
+	}
+	if n := c.NotificationsFor(c.FindEnv("staging")); n["slackChannel"] != "#deploy" {
+		t.Errorf("unexpected notification options %v", n)
+	}
+	if c.TimeoutFor(prod) != 30*time.Minute || c.TimeoutFor(c.FindEnv("staging")) != 10*time.Minute {
+		t.Errorf("unexpected timeouts %v", c)
+	}
+	if prod.CanDeploy("alice", "alice") != nil || prod.CanDeploy("alice", "bob") == nil || prod.CanDeploy("bob", "bob") == nil {
+		t.Errorf("unexpected lock rules %v", prod.Lock)
+	}
+	if c.Scripts.Deploy != ".deploy/bin/deploy" {
+		t.Errorf("unexpected default script %q", c.Scripts.Deploy)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, s := range []string{
+		"envs:\n  - color: red\n",
+		"envs:\n  - name: a\n  - name: a\n",
+		"envs:\n  - name: a\n    color: '#12'\n",
+		"scripts:\n  deploy: ../../bin/sh\n",
+		"env:\n  FOO-BAR: x\n",
+		"env:\n  DEPLOY_ENV: x\n",
+		"params:\n  - name: foo\n  - name: FOO\n",
+		"unknown: 1\n",
+	} {
+		if _, err := parseConfig([]byte(s)); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestResolveParams(t *testing.T) {
+	c, err := parseConfig([]byte(`
+params:
+  - name: canary
+    type: number
+    default: "10"
+    min: 0
+    max: 100
+envs:
+  - name: production
+    params:
+      - name: skip_migrations
+        type: checkbox
+      - name: hosts
+        type: select
+        options: [all, web1]
+        default: all
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.setDefaults()
+	prod := c.FindEnv("production")
+
+	params, err := prod.ResolveParams(map[string]string{"skip_migrations": "on"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(params.EnvVars(), " ") != "DEPLOY_PARAM_CANARY=10 DEPLOY_PARAM_HOSTS=all DEPLOY_PARAM_SKIP_MIGRATIONS=1" {
+		t.Errorf("unexpected params %v", params.EnvVars())
+	}
+
+	for _, values := range []map[string]string{
+		{"canary": "101"},
+		{"canary": "x"},
+		{"hosts": "web2"},
+		{"skip_migrations": "maybe"},
+		{"unknown": "1"},
+	} {
+		if _, err := prod.ResolveParams(values); err == nil {
+			t.Errorf("expected error for %v", values)
+		}
+	}
+}
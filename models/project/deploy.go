@@ -0,0 +1,291 @@
+package project
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/edvakf/go-pploy/models/credentials"
+	"github.com/edvakf/go-pploy/models/gitutil"
+	"github.com/edvakf/go-pploy/models/locks"
+	"github.com/edvakf/go-pploy/models/notify"
+	"github.com/edvakf/go-pploy/models/redact"
+	"github.com/edvakf/go-pploy/models/secrets"
+	"github.com/edvakf/go-pploy/models/workdir"
+	"github.com/edvakf/go-pploy/unbuffered"
+	"github.com/pkg/errors"
+)
+
+// Deploy phases. Only the deploy phase is required, others run when their scripts exist.
+//
+//	pre_deploy -> deploy -> post_deploy
+//
+// on_failure runs when any of them fails, with DEPLOY_FAILED_PHASE set.
+// post_deploy and on_failure get DEPLOY_EXIT_STATUS of the deploy (or the failed phase).
+const (
+	PhasePreDeploy  = "pre_deploy"
+	PhaseDeploy     = "deploy"
+	PhasePostDeploy = "post_deploy"
+	PhaseOnFailure  = "on_failure"
+)
+
+// exit status reported for timed out phases, same as timeout(1)
+const timeoutExitStatus = 124
+
+// onFailureTimeout is how long on_failure may run when the deploy has a timeout.
+// on_failure does not count towards the timeout of the deploy, so that it can clean up after a timed out phase.
+var onFailureTimeout = 5 * time.Minute
+
+// Deploy runs project's deploy script with its hook scripts after checking the lock rules and the params of the environment.
+// The returned reader must be closed, after which the deploy keeps running and writes only to the log file.
+func (p *Project) Deploy(env string, user string, values map[string]string) (io.ReadCloser, error) {
+	config, err := readConfig(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	envConfig := config.FindEnv(env)
+	if envConfig == nil {
+		return nil, errors.Errorf("unknown deploy environment %q", env)
+	}
+	lockUser := ""
+	if l := locks.Check(string(p.Name), time.Now()); l != nil {
+		lockUser = l.User
+	}
+	err = envConfig.CanDeploy(user, lockUser)
+	if err != nil {
+		return nil, err
+	}
+	params, err := envConfig.ResolveParams(values)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := workdir.ProjectDir(p.Name)
+	commit, err := gitutil.ResolveRef(dir, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	subject := ""
+	if commits, _, err := gitutil.Log(dir, gitutil.LogOptions{Ref: commit, Limit: 1}); err == nil && len(commits) != 0 {
+		subject = commits[0].Subject
+	}
+	meta, err := readMeta(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	secretValues, err := secrets.ForEnv(p.Name, env)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := startRun(p.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// write to the log file of the run
+	f, err := workdir.CreateRunLog(p.Name, RunDeploy, run.id)
+	if err != nil {
+		finishRun(p.Name)
+		return nil, err
+	}
+
+	// secrets and configured patterns are masked both in the log file and in the response
+	masked := credentials.Secrets(p.Name)
+	secretVars := []string{}
+	for name, value := range secretValues {
+		masked = append(masked, value)
+		secretVars = append(secretVars, name+"="+value)
+	}
+	sort.Strings(secretVars)
+
+	pr, pw := io.Pipe()
+	out := newDeployLog(f, pw, redact.New(masked))
+	run.out = out
+
+	vars := []string{
+		"DEPLOY_PROJECT=" + string(p.Name),
+		"DEPLOY_ENV=" + env,
+		"DEPLOY_USER=" + user,
+		"DEPLOY_COMMIT=" + commit,
+		"DEPLOY_PREV_COMMIT=" + meta.Deployed[env],
+		"DEPLOY_LOG=" + workdir.RunLogFile(p.Name, RunDeploy, run.id),
+		"DEPLOY_RUN_ID=" + run.id,
+	}
+	vars = append(vars, params.EnvVars()...)
+
+	d := &deployment{
+		dir:     dir,
+		config:  config,
+		env:     append(scriptEnv(config, envConfig, append(secretVars, vars...)), credentials.GitEnv(p.Name)...),
+		run:     run,
+		out:     out,
+		timeout: config.TimeoutFor(envConfig),
+		limits:  config.LimitsFor(envConfig),
+		stdin:   config.InteractiveFor(envConfig),
+	}
+
+	go func() {
+		out.header(&LogRecord{
+			Kind:    RunDeploy,
+			Project: string(p.Name),
+			Env:     env,
+			User:    user,
+			Commit:  commit,
+			Params:  params,
+			RunID:   run.id,
+		})
+
+		status := d.runPhases()
+		if status == 0 {
+			err := p.recordDeployed(env, commit)
+			if err != nil {
+				fmt.Fprintln(out, err)
+			}
+		}
+
+		out.footer(status)
+		finishRun(p.Name)
+		f.Close()
+		notify.Send(&notify.Event{
+			Type:       notify.Deployed,
+			Project:    string(p.Name),
+			User:       user,
+			Env:        env,
+			RunID:      run.id,
+			Commit:     commit,
+			Subject:    subject,
+			ExitStatus: status,
+			Duration:   time.Since(out.started),
+			Options:    config.NotificationsFor(envConfig),
+		})
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
+// recordDeployed remembers the commit deployed to the environment, which becomes DEPLOY_PREV_COMMIT of the next deploy
+func (p *Project) recordDeployed(env string, commit string) error {
+	meta, err := readMeta(p.Name)
+	if err != nil {
+		return err
+	}
+	if meta.Deployed == nil {
+		meta.Deployed = map[string]string{}
+	}
+	meta.Deployed[env] = commit
+	return writeMeta(p.Name, meta)
+}
+
+// deployment runs the phases of a deploy one by one
+type deployment struct {
+	dir      string
+	config   *Config
+	env      []string
+	run      *run
+	out      io.Writer
+	timeout  time.Duration // for all phases in total
+	limits   Limits
+	stdin    bool // whether scripts get a pipe for answers of prompts
+	deadline time.Time
+}
+
+// runPhases runs the phases and returns the exit status of the deploy
+func (d *deployment) runPhases() int {
+	if d.timeout > 0 {
+		d.deadline = time.Now().Add(d.timeout)
+	}
+
+	failedPhase := ""
+	status, ran := d.runPhase(PhasePreDeploy, d.config.Scripts.PreDeploy, nil)
+	if ran && status != 0 {
+		failedPhase = PhasePreDeploy
+	} else {
+		status, _ = d.runPhase(PhaseDeploy, d.config.Scripts.Deploy, nil)
+		if status != 0 {
+			failedPhase = PhaseDeploy
+		}
+		postStatus, ran := d.runPhase(PhasePostDeploy, d.config.Scripts.PostDeploy, []string{
+			fmt.Sprintf("DEPLOY_EXIT_STATUS=%d", status),
+		})
+		if ran && postStatus != 0 && status == 0 {
+			failedPhase = PhasePostDeploy
+			status = postStatus
+		}
+	}
+
+	if failedPhase != "" {
+		d.runPhase(PhaseOnFailure, d.config.Scripts.OnFailure, []string{
+			fmt.Sprintf("DEPLOY_EXIT_STATUS=%d", status),
+			"DEPLOY_FAILED_PHASE=" + failedPhase,
+		})
+		fmt.Fprintf(d.out, "=== %s failed with exit status %d ===\n", failedPhase, status)
+	}
+	return status
+}
+
+// runPhase runs the script of a phase, and returns its exit status and whether it ran.
+// Scripts of phases other than deploy are skipped when they do not exist.
+func (d *deployment) runPhase(phase string, script string, extraEnv []string) (int, bool) {
+	path := d.dir + "/" + script
+	if phase != PhaseDeploy && !fileExists(path) {
+		return 0, false
+	}
+
+	timeout, limit := time.Duration(0), d.timeout
+	if phase == PhaseOnFailure && d.timeout > 0 {
+		timeout, limit = onFailureTimeout, onFailureTimeout
+	} else if !d.deadline.IsZero() {
+		timeout = time.Until(d.deadline)
+		if timeout <= 0 {
+			fmt.Fprintf(d.out, "=== %s skipped because the deploy timed out ===\n", phase)
+			return timeoutExitStatus, false
+		}
+	}
+
+	fmt.Fprintf(d.out, "=== %s ===\n", phase)
+
+	cmd := unbuffered.Command(path)
+	cmd.Dir = d.dir
+	cmd.Env = append(append([]string{}, d.env...), extraEnv...)
+	cmd.Env = append(cmd.Env, "DEPLOY_PHASE="+phase)
+	if d.stdin {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			fmt.Fprintln(d.out, errors.Wrap(err, "failed to get stdin pipe"))
+			return 1, true
+		}
+		d.run.setStdin(stdin)
+	} else {
+		d.run.setStdin(nil)
+	}
+
+	err := streamCommand(cmd, d.out, streamOptions{
+		onLine:  d.run.handleLine,
+		timeout: timeout,
+		limits:  d.limits,
+	})
+	if _, ok := err.(*TimeoutError); ok {
+		fmt.Fprintf(d.out, "# %s timed out after %s and was killed\n", phase, limit)
+	} else if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		fmt.Fprintln(d.out, err)
+	}
+	return exitStatus(err), true
+}
+
+// exitStatus converts the error of streamCommand into an exit status
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if _, ok := err.(*TimeoutError); ok {
+		return timeoutExitStatus
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
@@ -0,0 +1,77 @@
+package project
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edvakf/go-pploy/models/redact"
+)
+
+func TestRunPhasesOnFailureAfterTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	scripts := map[string]string{
+		"deploy":     "#!/bin/sh\nsleep 10\n",
+		"on_failure": "#!/bin/sh\necho cleanup $DEPLOY_EXIT_STATUS $DEPLOY_FAILED_PHASE\n",
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(dir+"/"+name, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var file, client bytes.Buffer
+	d := &deployment{
+		dir:     dir,
+		config:  &Config{Scripts: ScriptsConfig{PreDeploy: "pre_deploy", Deploy: "deploy", PostDeploy: "post_deploy", OnFailure: "on_failure"}},
+		run:     &run{},
+		out:     newDeployLog(&file, &client, redact.New(nil)),
+		timeout: 100 * time.Millisecond,
+	}
+	status := d.runPhases()
+	if status != timeoutExitStatus {
+		t.Errorf("unexpected exit status %d", status)
+	}
+	if out := client.String(); !strings.Contains(out, "=== on_failure ===\ncleanup 124 deploy\n") {
+		t.Errorf("on_failure did not run after the timeout: %q", out)
+	}
+}
+
+func TestRunPhasesWithoutStdin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := "#!/bin/sh\necho '::pploy-confirm::Continue?'\nread x\necho done\n"
+	if err := ioutil.WriteFile(dir+"/deploy", []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var file, client bytes.Buffer
+	d := &deployment{
+		dir:    dir,
+		config: &Config{Scripts: ScriptsConfig{PreDeploy: "pre_deploy", Deploy: "deploy", PostDeploy: "post_deploy", OnFailure: "on_failure"}},
+		run:    &run{},
+		out:    newDeployLog(&file, &client, redact.New(nil)),
+	}
+	done := make(chan int)
+	go func() { done <- d.runPhases() }()
+	select {
+	case status := <-done:
+		if status != 0 || !strings.Contains(client.String(), "not interactive)\ndone\n") {
+			t.Errorf("unexpected result %d %q", status, client.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the script reading stdin does not finish")
+	}
+}
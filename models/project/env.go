@@ -0,0 +1,43 @@
+package project
+
+import (
+	"os"
+	"strings"
+)
+
+// InheritedEnv lists environment variables of the server passed to checkout and deploy scripts.
+// A name ending with "*" matches all variables starting with the rest of it.
+var InheritedEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_*", "TZ", "TMPDIR", "SSH_AUTH_SOCK"}
+
+// scriptEnv returns the environment of a checkout or deploy script, which consists of
+// inherited variables, variables of the project config and extra variables (secrets and DEPLOY_*) in order of precedence
+func scriptEnv(config *Config, env *EnvConfig, extra []string) []string {
+	a := inheritedEnv()
+	a = append(a, config.EnvVars(env)...)
+	return append(a, extra...)
+}
+
+// inheritedEnv returns environment variables of the server allowed by InheritedEnv
+func inheritedEnv() []string {
+	a := []string{}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if envInherited(name) {
+			a = append(a, kv)
+		}
+	}
+	return a
+}
+
+func envInherited(name string) bool {
+	for _, pattern := range InheritedEnv {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package project
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestScriptEnv(t *testing.T) {
+	os.Setenv("PPLOY_TEST_SECRET", "x")
+	os.Setenv("LC_PPLOY_TEST", "y")
+	defer os.Unsetenv("PPLOY_TEST_SECRET")
+	defer os.Unsetenv("LC_PPLOY_TEST")
+
+	c := &Config{Env: map[string]string{"APP": "foo"}}
+	env := strings.Join(scriptEnv(c, nil, []string{"DEPLOY_COMMIT=abc"}), "\n")
+	if strings.Contains(env, "PPLOY_TEST_SECRET") {
+		t.Error("variables not in InheritedEnv must not be passed")
+	}
+	for _, kv := range []string{"LC_PPLOY_TEST=y", "APP=foo", "DEPLOY_COMMIT=abc"} {
+		if !strings.Contains(env, kv) {
+			t.Errorf("%s is not passed", kv)
+		}
+	}
+}
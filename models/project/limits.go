@@ -0,0 +1,14 @@
+package project
+
+import "time"
+
+// Limits are resource limits of a deploy script. Zero values mean no limit.
+type Limits struct {
+	CPUTime   time.Duration `yaml:"cpuTime" json:"cpuTime"`
+	MemoryMB  uint64        `yaml:"memoryMB" json:"memoryMB"`
+	OpenFiles uint64        `yaml:"openFiles" json:"openFiles"`
+}
+
+func (l Limits) empty() bool {
+	return l.CPUTime == 0 && l.MemoryMB == 0 && l.OpenFiles == 0
+}
@@ -0,0 +1,41 @@
+package project
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// limitCommand rewrites cmd to run under `sh -c 'ulimit ...; exec "$@"'`, so that the limits are set
+// before the command is executed and are inherited by every process it starts.
+func limitCommand(cmd *exec.Cmd, l Limits) error {
+	if l.empty() {
+		return nil
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return errors.Wrap(err, "failed to find sh to apply resource limits")
+	}
+
+	ulimits := []string{}
+	if l.CPUTime > 0 {
+		sec := uint64(l.CPUTime.Seconds())
+		if sec == 0 {
+			sec = 1
+		}
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -t %d", sec))
+	}
+	if l.MemoryMB > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", l.MemoryMB*1024))
+	}
+	if l.OpenFiles > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", l.OpenFiles))
+	}
+	script := strings.Join(ulimits, " && ") + ` && exec "$@"`
+
+	cmd.Args = append([]string{"sh", "-c", script, "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sh
+	return nil
+}
@@ -0,0 +1,22 @@
+package project
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestLimitCommand(t *testing.T) {
+	// the limit is inherited by a grandchild process as well
+	cmd := exec.Command("sh", "-c", "sh -c 'ulimit -n'")
+	err := limitCommand(cmd, Limits{OpenFiles: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "64\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package project
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// limitCommand is only supported on Linux
+func limitCommand(cmd *exec.Cmd, l Limits) error {
+	if l.empty() {
+		return nil
+	}
+	return errors.New("resource limits are only supported on Linux")
+}
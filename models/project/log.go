@@ -0,0 +1,485 @@
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edvakf/go-pploy/models/headreader"
+	"github.com/edvakf/go-pploy/models/redact"
+	"github.com/edvakf/go-pploy/models/workdir"
+	"github.com/pkg/errors"
+)
+
+// Formats of deploy log files
+const (
+	LogFormatText = "text" // output as it is
+	LogFormatJSON = "json" // LogRecord per line
+)
+
+// LogFormat is the format new deploy log files are written in.
+// LogReader renders both formats as plain text.
+var LogFormat = LogFormatText
+
+// Streams of log lines
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamPploy  = "pploy" // messages of go-pploy itself
+)
+
+// Kinds of runs written to log files, whose logs are kept and counted separately
+const (
+	RunDeploy   = workdir.KindDeploy
+	RunCheckout = workdir.KindCheckout
+)
+
+// LogRecord is a line of a log file in the JSON format
+type LogRecord struct {
+	Type string    `json:"type"` // header, line or footer
+	Time time.Time `json:"time"`
+
+	// line
+	Stream string `json:"stream,omitempty"`
+	Line   string `json:"line,omitempty"`
+
+	// header
+	Kind    string `json:"kind,omitempty"` // RunDeploy when empty
+	Project string `json:"project,omitempty"`
+	Ref     string `json:"ref,omitempty"` // checkout
+	Env     string `json:"env,omitempty"` // deploy
+	User    string `json:"user,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Params  Params `json:"params,omitempty"`
+	RunID   string `json:"runId,omitempty"`
+
+	// footer
+	ExitStatus *int    `json:"exitStatus,omitempty"`
+	Duration   float64 `json:"duration,omitempty"` // seconds
+}
+
+// Types of LogRecord
+const (
+	recordHeader = "header"
+	recordLine   = "line"
+	recordFooter = "footer"
+)
+
+// lineWriter is implemented by writers which record the stream of each line
+type lineWriter interface {
+	WriteLine(stream string, line string)
+}
+
+// writeLine writes a line without the trailing newline to w
+func writeLine(w io.Writer, stream string, line string) {
+	if lw, ok := w.(lineWriter); ok {
+		lw.WriteLine(stream, line)
+		return
+	}
+	io.WriteString(w, line+"\n")
+}
+
+// deployLog writes masked output of a deploy or a checkout to the log file in LogFormat and to the client as plain text.
+// It is safe for concurrent use.
+type deployLog struct {
+	mu      sync.Mutex
+	file    io.Writer
+	client  io.Writer
+	format  string
+	filter  *redact.Filter
+	started time.Time
+}
+
+func newDeployLog(file io.Writer, client io.Writer, filter *redact.Filter) *deployLog {
+	return &deployLog{
+		file:    file,
+		client:  client,
+		format:  LogFormat,
+		filter:  filter,
+		started: time.Now(),
+	}
+}
+
+// Write implements the io.Writer interface. b is written as messages of go-pploy.
+func (l *deployLog) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		l.WriteLine(StreamPploy, line)
+	}
+	return len(b), nil
+}
+
+// WriteLine implements the lineWriter interface
+func (l *deployLog) WriteLine(stream string, line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	line = l.filter.String(line)
+	io.WriteString(l.client, line+"\n")
+	if l.format == LogFormatJSON {
+		l.writeRecord(&LogRecord{Type: recordLine, Time: time.Now(), Stream: stream, Line: line})
+	} else {
+		io.WriteString(l.file, line+"\n")
+	}
+}
+
+func (l *deployLog) header(h *LogRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h.Type = recordHeader
+	h.Time = l.started
+	params := Params{}
+	for k, v := range h.Params {
+		params[k] = l.filter.String(v)
+	}
+	h.Params = params
+	text := renderRecord(h, false)
+	io.WriteString(l.client, text)
+	if l.format == LogFormatJSON {
+		l.writeRecord(h)
+	} else {
+		io.WriteString(l.file, text)
+	}
+}
+
+func (l *deployLog) footer(exitStatus int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	f := &LogRecord{
+		Type:       recordFooter,
+		Time:       now,
+		ExitStatus: &exitStatus,
+		Duration:   now.Sub(l.started).Seconds(),
+	}
+	text := renderRecord(f, false)
+	io.WriteString(l.client, text)
+	if l.format == LogFormatJSON {
+		l.writeRecord(f)
+	} else {
+		io.WriteString(l.file, text)
+	}
+}
+
+func (l *deployLog) writeRecord(r *LogRecord) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return // should not happen
+	}
+	l.file.Write(append(b, '\n'))
+}
+
+// renderRecord formats a record as plain text lines
+func renderRecord(r *LogRecord, timestamps bool) string {
+	var buf bytes.Buffer
+	prefix := ""
+	if timestamps {
+		prefix = r.Time.Format("[15:04:05.000] ")
+	}
+	switch r.Type {
+	case recordHeader:
+		if r.Kind == RunCheckout {
+			fmt.Fprintf(&buf, "%s# checkout %s by %s\n", prefix, r.Ref, r.User)
+		} else {
+			fmt.Fprintf(&buf, "%s# deploy to %s by %s\n", prefix, r.Env, r.User)
+		}
+		if r.Commit != "" {
+			fmt.Fprintf(&buf, "%s# commit: %s\n", prefix, r.Commit)
+		}
+		if len(r.Params) != 0 {
+			fmt.Fprintf(&buf, "%s# params: %s\n", prefix, r.Params)
+		}
+	case recordFooter:
+		if r.ExitStatus != nil {
+			fmt.Fprintf(&buf, "%s# finished with exit status %d in %.1fs\n", prefix, *r.ExitStatus, r.Duration)
+		}
+	default:
+		fmt.Fprintf(&buf, "%s%s\n", prefix, r.Line)
+	}
+	return buf.String()
+}
+
+// LogOptions are options of LogReader
+type LogOptions struct {
+	Full       bool // otherwise first 10000 bytes, unless Tail or Follow is set
+	Tail       int  // read only last lines (records for logs in the JSON format) when positive
+	Follow     bool // keep reading while the deploy writing the log is running
+	Timestamps bool // prefix lines with time, only for logs in the JSON format
+}
+
+// how often a followed log file is checked for appended data
+var followInterval = 200 * time.Millisecond
+
+// RunIDs returns IDs of the runs of the kinds of the project which have log files, newest first
+func (p *Project) RunIDs(kinds ...string) ([]string, error) {
+	return workdir.RunIDs(p.Name, kinds...)
+}
+
+// RunIDOfGeneration returns the ID of the deploy generation deploys before the latest one,
+// or an empty string when its log does not exist. Checkouts are not counted.
+func (p *Project) RunIDOfGeneration(generation int) (string, error) {
+	ids, err := p.RunIDs(RunDeploy)
+	if err != nil {
+		return "", err
+	}
+	if generation < 0 || generation >= len(ids) {
+		return "", nil
+	}
+	return ids[generation], nil
+}
+
+// LogReader returns a ReadCloser which reads the log file of a deploy rendered as plain text
+func (p *Project) LogReader(runID string, opts LogOptions) (io.ReadCloser, error) {
+	f, err := openLogFile(p.Name, runID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &EmptyReadCloser{}, nil
+		}
+		return nil, err
+	}
+	isJSON := isJSONLog(f)
+
+	if opts.Tail > 0 {
+		offset, err := tailOffset(f, f.size, opts.Tail)
+		if err == nil {
+			_, err = f.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "failed to read log file")
+		}
+	}
+
+	var r io.Reader = f
+	following := false
+	if opts.Follow {
+		if run := findRun(p.Name); run != nil && run.id == runID {
+			r = &followReader{r: f, running: func() bool { return findRun(p.Name) == run }}
+			following = true
+		}
+	}
+	if isJSON {
+		r = &logRenderer{br: bufio.NewReader(r), timestamps: opts.Timestamps}
+	}
+
+	rc := &readCloser{r, f}
+	if opts.Full || opts.Tail > 0 || following {
+		return rc, nil
+	}
+	return headreader.New(rc, 10000), nil // first 10000 bytes
+}
+
+// LogContent is a log file rendered as plain text, which can be seeked for range requests
+type LogContent struct {
+	io.ReadSeeker
+	ModTime time.Time
+	closer  io.Closer
+}
+
+// Close implements the io.Closer interface
+func (lc *LogContent) Close() error {
+	if lc.closer == nil {
+		return nil
+	}
+	return lc.closer.Close()
+}
+
+// LogContent returns the whole log file of a deploy rendered as plain text.
+// Logs in the JSON format are rendered in memory.
+func (p *Project) LogContent(runID string, timestamps bool) (*LogContent, error) {
+	f, err := openLogFile(p.Name, runID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &LogContent{ReadSeeker: bytes.NewReader(nil)}, nil
+		}
+		return nil, err
+	}
+	if !isJSONLog(f) {
+		return &LogContent{ReadSeeker: f, ModTime: f.modTime, closer: f}, nil
+	}
+
+	defer f.Close()
+	b, err := ioutil.ReadAll(&logRenderer{br: bufio.NewReader(f), timestamps: timestamps})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read log file")
+	}
+	return &LogContent{ReadSeeker: bytes.NewReader(b), ModTime: f.modTime}, nil
+}
+
+// isJSONLog detects the format of a log file by its first byte.
+// Empty files, which are about to be written, are in the current LogFormat.
+func isJSONLog(f io.ReaderAt) bool {
+	b := make([]byte, 1)
+	_, err := f.ReadAt(b, 0)
+	if err != nil {
+		return LogFormat == LogFormatJSON
+	}
+	return b[0] == '{'
+}
+
+// tailOffset returns the offset of the last n lines of a file
+func tailOffset(f io.ReaderAt, size int64, n int) (int64, error) {
+	end := size
+	if end > 0 {
+		end-- // the newline of the last line
+	}
+	buf := make([]byte, 4096)
+	count := 0
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		_, err := f.ReadAt(chunk, start)
+		if err != nil {
+			return 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] == '\n' {
+				count++
+				if count == n {
+					return start + int64(i) + 1, nil
+				}
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// followReader reads a file being written by a running deploy, waiting for appended data at EOF until the deploy finishes
+type followReader struct {
+	r       io.Reader
+	running func() bool
+}
+
+// Read implements the io.Reader interface
+func (fr *followReader) Read(b []byte) (int, error) {
+	for {
+		n, err := fr.r.Read(b)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		if !fr.running() {
+			// the deploy may have written the rest before finishing
+			return fr.r.Read(b)
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+// logFile is a log file opened for reading, which is decompressed in memory when gzipped
+type logFile struct {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+	size    int64
+	modTime time.Time
+}
+
+// openLogFile opens the log file of a run, and fails with a not-exist error for invalid run IDs
+func openLogFile(name workdir.Name, runID string) (*logFile, error) {
+	if !workdir.ValidRunID(runID) {
+		return nil, os.ErrNotExist
+	}
+	path, gzipped := workdir.FindRunLog(name, runID)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to open log file")
+	}
+	if !gzipped {
+		return &logFile{f, f, f, fi.Size(), fi.ModTime()}, nil
+	}
+
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress log file")
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress log file")
+	}
+	r := bytes.NewReader(b)
+	return &logFile{r, r, &EmptyReadCloser{}, int64(len(b)), fi.ModTime()}, nil
+}
+
+// openLogStream opens the log file of a run rendered as plain text without reading it into memory,
+// and fails with a not-exist error for invalid run IDs
+func openLogStream(name workdir.Name, runID string) (io.ReadCloser, error) {
+	if !workdir.ValidRunID(runID) {
+		return nil, os.ErrNotExist
+	}
+	path, gzipped := workdir.FindRunLog(name, runID)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = f
+	if gzipped {
+		r, err = gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "failed to decompress log file")
+		}
+	}
+
+	br := bufio.NewReader(r)
+	first, err := br.Peek(1)
+	if (err == nil && first[0] == '{') || (err != nil && LogFormat == LogFormatJSON) {
+		return &readCloser{&logRenderer{br: br}, f}, nil
+	}
+	return &readCloser{br, f}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// logRenderer renders a log file in the JSON format as plain text
+type logRenderer struct {
+	br         *bufio.Reader
+	timestamps bool
+	buf        bytes.Buffer
+}
+
+// Read implements the io.Reader interface
+func (lr *logRenderer) Read(b []byte) (int, error) {
+	for lr.buf.Len() == 0 {
+		line, err := lr.br.ReadBytes('\n')
+		if len(line) != 0 {
+			r := &LogRecord{}
+			if json.Unmarshal(line, r) == nil {
+				lr.buf.WriteString(renderRecord(r, lr.timestamps))
+			} else {
+				lr.buf.Write(line) // a broken record is shown as it is
+			}
+		}
+		if err != nil {
+			if lr.buf.Len() == 0 {
+				return 0, err
+			}
+			break
+		}
+	}
+	return lr.buf.Read(b)
+}
@@ -0,0 +1,61 @@
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edvakf/go-pploy/models/redact"
+)
+
+func TestDeployLogJSON(t *testing.T) {
+	var file, client bytes.Buffer
+	l := newDeployLog(&file, &client, redact.New([]string{"s3cret"}))
+	l.format = LogFormatJSON
+
+	l.header(&LogRecord{Env: "production", User: "alice", Commit: "abc", Params: Params{"canary": "10"}})
+	writeLine(l, StreamStdout, "token s3cret")
+	writeLine(l, StreamStderr, "")
+	l.Write([]byte("=== deploy ===\n"))
+	l.footer(1)
+
+	lines := strings.Split(strings.TrimSpace(file.String()), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[0], `"type":"header"`) || !strings.Contains(lines[2], `"stream":"stderr"`) || !strings.Contains(lines[4], `"exitStatus":1`) {
+		t.Errorf("unexpected records %s", file.String())
+	}
+
+	b, err := ioutil.ReadAll(&logRenderer{br: bufio.NewReader(&file)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != client.String() {
+		t.Errorf("rendered log %q differs from the output %q", b, client.String())
+	}
+	if !strings.HasPrefix(string(b), "# deploy to production by alice\n# commit: abc\n# params: canary=\"10\"\ntoken ***\n\n=== deploy ===\n# finished with exit status 1 in ") {
+		t.Errorf("unexpected output %q", b)
+	}
+}
+
+func TestTailOffset(t *testing.T) {
+	f, err := ioutil.TempFile("", "pploy-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	content := strings.Repeat("0123456789\n", 1000)
+	f.WriteString(content)
+
+	for n, expected := range map[int]int64{1: 10989, 3: 10967, 1000: 0, 2000: 0} {
+		offset, err := tailOffset(f, int64(len(content)), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != expected {
+			t.Errorf("expected offset %d for %d lines, got %d", expected, n, offset)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package project
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Param types
+const (
+	ParamText     = "text"
+	ParamNumber   = "number"
+	ParamCheckbox = "checkbox"
+	ParamSelect   = "select"
+)
+
+// ParamConfig declares a deploy parameter which is passed to the deploy script
+// as an environment variable DEPLOY_PARAM_<NAME>
+type ParamConfig struct {
+	Name     string   `yaml:"name" json:"name"`
+	Label    string   `yaml:"label" json:"label"`
+	Type     string   `yaml:"type" json:"type"`
+	Default  string   `yaml:"default" json:"default"`
+	Required bool     `yaml:"required" json:"required"`
+	Options  []string `yaml:"options" json:"options"` // for select
+	Min      *float64 `yaml:"min" json:"min"`         // for number
+	Max      *float64 `yaml:"max" json:"max"`         // for number
+	Pattern  string   `yaml:"pattern" json:"pattern"` // for text
+}
+
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (pc *ParamConfig) validate() error {
+	if !paramNamePattern.MatchString(pc.Name) {
+		return errors.Errorf("invalid name %q (use letters, digits and '_')", pc.Name)
+	}
+	switch pc.Type {
+	case ParamText, "":
+		if pc.Pattern != "" {
+			if _, err := regexp.Compile(pc.Pattern); err != nil {
+				return errors.Wrap(err, "invalid pattern")
+			}
+		}
+	case ParamNumber, ParamCheckbox:
+	case ParamSelect:
+		if len(pc.Options) == 0 {
+			return errors.New("options are required for select")
+		}
+	default:
+		return errors.Errorf("unknown type %q (use text, number, checkbox or select)", pc.Type)
+	}
+	if pc.Default != "" {
+		if _, err := pc.normalize(pc.Default); err != nil {
+			return errors.Wrap(err, "invalid default")
+		}
+	}
+	return nil
+}
+
+// normalize validates a value given by users. Checkbox values become "1" or "0".
+func (pc *ParamConfig) normalize(v string) (string, error) {
+	switch pc.Type {
+	case ParamNumber:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", errors.Errorf("%q is not a number", v)
+		}
+		if pc.Min != nil && f < *pc.Min {
+			return "", errors.Errorf("%s must be at least %v", v, *pc.Min)
+		}
+		if pc.Max != nil && f > *pc.Max {
+			return "", errors.Errorf("%s must be at most %v", v, *pc.Max)
+		}
+	case ParamCheckbox:
+		switch strings.ToLower(v) {
+		case "1", "on", "true", "yes":
+			return "1", nil
+		case "0", "off", "false", "no", "":
+			return "0", nil
+		}
+		return "", errors.Errorf("%q is not a checkbox value", v)
+	case ParamSelect:
+		for _, o := range pc.Options {
+			if o == v {
+				return v, nil
+			}
+		}
+		return "", errors.Errorf("%q is not one of %s", v, strings.Join(pc.Options, ", "))
+	case ParamText, "":
+		if pc.Pattern != "" && !regexp.MustCompile(pc.Pattern).MatchString(v) {
+			return "", errors.Errorf("%q does not match %s", v, pc.Pattern)
+		}
+	}
+	return v, nil
+}
+
+// EnvName returns the name of the environment variable for the parameter
+func (pc *ParamConfig) EnvName() string {
+	return "DEPLOY_PARAM_" + strings.ToUpper(pc.Name)
+}
+
+func validateParams(params []ParamConfig) error {
+	seen := map[string]bool{}
+	for i, pc := range params {
+		err := pc.validate()
+		if err != nil {
+			return errors.Wrapf(err, "params[%d]", i)
+		}
+		// names differing only in case share the same environment variable
+		if seen[pc.EnvName()] {
+			return errors.Errorf("params[%d]: duplicate name %q", i, pc.Name)
+		}
+		seen[pc.EnvName()] = true
+	}
+	return nil
+}
+
+// mergeParams returns project params overridden and followed by env params.
+// Params are matched by their environment variable names.
+func mergeParams(project []ParamConfig, env []ParamConfig) []ParamConfig {
+	merged := []ParamConfig{}
+	index := map[string]int{}
+	for _, params := range [][]ParamConfig{project, env} {
+		for _, pc := range params {
+			if i, ok := index[pc.EnvName()]; ok {
+				merged[i] = pc
+				continue
+			}
+			index[pc.EnvName()] = len(merged)
+			merged = append(merged, pc)
+		}
+	}
+	return merged
+}
+
+// Params is a set of validated deploy parameter values
+type Params map[string]string
+
+// ResolveParams validates values given by users against the params of an environment
+// and fills in defaults. Unknown values are rejected.
+func (env *EnvConfig) ResolveParams(values map[string]string) (Params, error) {
+	known := map[string]bool{}
+	resolved := Params{}
+	for _, pc := range env.Params {
+		known[pc.Name] = true
+		v, ok := values[pc.Name]
+		if !ok || (v == "" && pc.Type != ParamCheckbox) {
+			v = pc.Default
+		}
+		if v == "" && pc.Type != ParamCheckbox {
+			if pc.Required {
+				return nil, errors.Errorf("param %s is required", pc.Name)
+			}
+			resolved[pc.Name] = ""
+			continue
+		}
+		n, err := pc.normalize(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "param "+pc.Name)
+		}
+		resolved[pc.Name] = n
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, errors.Errorf("unknown param %s", name)
+		}
+	}
+	return resolved, nil
+}
+
+// EnvVars returns the params as environment variables sorted by name
+func (params Params) EnvVars() []string {
+	a := []string{}
+	for _, name := range params.names() {
+		a = append(a, (&ParamConfig{Name: name}).EnvName()+"="+params[name])
+	}
+	return a
+}
+
+// String formats the params like `a="1" b="foo"` sorted by name
+func (params Params) String() string {
+	a := []string{}
+	for _, name := range params.names() {
+		a = append(a, fmt.Sprintf("%s=%s", name, strconv.Quote(params[name])))
+	}
+	return strings.Join(a, " ")
+}
+
+func (params Params) names() []string {
+	names := []string{}
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,14 @@
+package project
+
+import "os/exec"
+
+// setProcessGroup is not supported on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills only the command itself on Windows
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	cmd.Process.Kill()
+}
@@ -2,19 +2,23 @@ package project
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/edvakf/go-pploy/models/cache"
-	"github.com/edvakf/go-pploy/models/datadog"
-	"github.com/edvakf/go-pploy/models/headreader"
-	"github.com/edvakf/go-pploy/models/hook"
+	"github.com/edvakf/go-pploy/models/credentials"
+	"github.com/edvakf/go-pploy/models/gitutil"
+	"github.com/edvakf/go-pploy/models/jobs"
 	"github.com/edvakf/go-pploy/models/locks"
+	"github.com/edvakf/go-pploy/models/redact"
 	"github.com/edvakf/go-pploy/models/workdir"
 	"github.com/edvakf/go-pploy/unbuffered"
 	"github.com/pkg/errors"
@@ -22,11 +26,15 @@ import (
 
 // Project is a git-controlled deployable project directory
 type Project struct {
-	Lock          *locks.Lock `json:"lock"`
-	Name          string      `json:"name"`
-	DeployEnvs    []string    `json:"deployEnvs"`
-	Readme        string      `json:"readme"`
-	DefaultBranch string      `json:"defaultBranch"`
+	Lock          *locks.Lock  `json:"lock"`
+	Name          workdir.Name `json:"name"`
+	URL           string       `json:"url"`
+	DeployEnvs    []string     `json:"deployEnvs"`
+	Config        *Config      `json:"config"`
+	Prompt        *Prompt      `json:"prompt"`
+	Readme        string       `json:"readme"`
+	DefaultBranch string       `json:"defaultBranch"`
+	LastRunID     string       `json:"lastRunId"` // ID of the latest deploy which has a log file
 }
 
 // All returns all projects
@@ -38,20 +46,22 @@ func All() ([]Project, error) {
 	projects := []Project{}
 	now := time.Now()
 	for _, name := range names {
-		p, err := FromName(name)
+		p, err := FromName(string(name))
 		if err != nil {
 			continue // should not happen
 		}
-		p.Lock = locks.Check(name, now)
+		p.Lock = locks.Check(string(name), now)
+		p.URL = p.originURL()
 		projects = append(projects, *p)
 	}
 	return projects, nil
 }
 
-// FromName creates a Project from its name
-func FromName(name string) (*Project, error) {
-	if name == "" {
-		return nil, errors.New("name is empty")
+// FromName creates a Project from its name given by users
+func FromName(s string) (*Project, error) {
+	name, err := workdir.ParseName(s)
+	if err != nil {
+		return nil, err
 	}
 	dir := workdir.ProjectDir(name)
 	if !fileExists(dir) {
@@ -70,11 +80,18 @@ func Full(name string) (*Project, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = p.readDeployEnvs()
+	p.Config, err = readConfig(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	p.DeployEnvs = p.Config.EnvNames()
+	p.Lock = locks.Check(string(p.Name), time.Now())
+	p.URL = p.originURL()
+	p.Prompt = p.CurrentPrompt()
+	p.LastRunID, err = p.RunIDOfGeneration(0)
 	if err != nil {
 		return nil, err
 	}
-	p.Lock = locks.Check(p.Name, time.Now())
 
 	defaultBranch, err := p.GetCachedDefaultBranch()
 	if err != nil {
@@ -85,97 +102,208 @@ func Full(name string) (*Project, error) {
 	return p, nil
 }
 
-// Clone runs `git clone` for project repo
-func Clone(url string) (*Project, error) {
-	cmd := exec.Command("git", "clone", url, "--depth", "20", "--no-single-branch") // TODO: make it configurable
-	cmd.Dir = workdir.ProjectsDir()
-	err := cmd.Run()
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+// names which collide with the routes of the web server
+var reservedNames = map[string]bool{"api": true, "assets": true}
+
+// ParseNewName checks that s can be used as the name of a new project
+func ParseNewName(s string) (workdir.Name, error) {
+	name, err := workdir.ParseName(s)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to clone repo")
+		return "", err
+	}
+	if !namePattern.MatchString(s) || reservedNames[s] {
+		return "", errors.Errorf("invalid project name %q (use up to 100 letters, digits, '.', '_' or '-' starting with a letter or a digit)", s)
 	}
+	return name, nil
+}
 
-	// extract repo name
-	submatch := regexp.MustCompile(`([^/]+?)(?:\.git)?(?:/)?$`).FindStringSubmatch(url)
+// NameFromURL derives a project name from the repo URL
+func NameFromURL(url string) (string, error) {
+	submatch := regexp.MustCompile(`([^/:]+?)(?:\.git)?(?:/)?$`).FindStringSubmatch(url)
 	if submatch == nil {
-		return nil, errors.New("failed to determine repo name")
+		return "", errors.New("failed to determine repo name")
 	}
-	name := submatch[1]
-
-	return FromName(name)
+	return submatch[1], nil
 }
 
-// Checkout runs either default checkout command or checkout_overwrite script
-func (p *Project) Checkout(commit string) (io.Reader, error) {
-	var cmd *exec.Cmd
+// prevents two clones from taking the same name
+var cloneMu sync.Mutex
 
-	script := workdir.ProjectDir(p.Name) + "/.deploy/bin/checkout_overwrite"
-	if fileExists(script) {
-		cmd = unbuffered.Command("bash -x -c '" + script + "'")
+// Clone starts `git clone` for project repo with options as a background job.
+// When name is empty, it is derived from the URL.
+// Credential is stored and used for later git operations of the project when not empty.
+func Clone(url string, s string, opts CloneOptions, cred credentials.Credential) (*jobs.Job, error) {
+	var err error
+	if s == "" {
+		s, err = NameFromURL(url)
+		if err != nil {
+			return nil, err
+		}
+	}
+	name, err := ParseNewName(s)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Depth < 0 {
+		return nil, errors.New("depth must not be negative")
+	}
+
+	cloneMu.Lock()
+	defer cloneMu.Unlock()
+
+	if fileExists(workdir.ProjectDir(name)) || jobs.IsRunning("clone", string(name)) {
+		return nil, errors.Errorf("project %q already exists, please choose another name", name)
+	}
+
+	args := []string{"clone", "--progress"}
+	if opts.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(opts.Depth))
+	}
+	if opts.Branch != "" {
+		args = append(args, "--branch="+opts.Branch, "--single-branch")
 	} else {
-		cmd = checkoutCommand()
+		args = append(args, "--no-single-branch")
 	}
-	cmd.Dir = workdir.ProjectDir(p.Name)
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "DEPLOY_COMMIT="+commit)
+	if opts.Submodules {
+		args = append(args, "--recurse-submodules")
+	}
+	// cloned elsewhere first, so that the project is not listed until it is complete
+	args = append(args, "--", url, workdir.CloningDir(name))
 
-	return stdoutStderrReader(cmd, nil)
+	filter := redact.New([]string{cred.Token})
+	job := jobs.Start("clone", string(name), func(w io.Writer) error {
+		err := credentials.Save(name, cred)
+		if err != nil {
+			return err
+		}
+		w = filter.Writer(w)
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("git", args...)
+		cmd.Dir = workdir.WorkDir()
+		cmd.Env = append(os.Environ(), credentials.GitEnv(name)...)
+		cmd.Stdout = w
+		cmd.Stderr = io.MultiWriter(w, &stderr)
+		err = cmd.Run()
+		if err != nil {
+			credentials.Remove(name)
+			os.RemoveAll(workdir.CloningDir(name))
+			return errors.Wrap(err, "failed to clone repo: "+filter.String(collapseProgress(stderr.String())))
+		}
+
+		err = writeMeta(name, &Meta{URL: stripUserinfo(url), CloneOptions: opts})
+		if err == nil {
+			err = os.Rename(workdir.CloningDir(name), workdir.ProjectDir(name))
+			if err != nil {
+				err = errors.Wrap(err, "failed to move cloned repo")
+			}
+		}
+		if err != nil {
+			credentials.Remove(name)
+			os.RemoveAll(workdir.CloningDir(name))
+			os.Remove(workdir.MetaFile(name))
+		}
+		return err
+	})
+	return job, nil
 }
 
-// Deploy runs project's deploy script
-func (p *Project) Deploy(env string, user string) (io.Reader, error) {
-	script := workdir.ProjectDir(p.Name) + "/.deploy/bin/deploy"
-	cmd := unbuffered.Command(script)
-	cmd.Dir = workdir.ProjectDir(p.Name)
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "DEPLOY_ENV="+env)
-	cmd.Env = append(cmd.Env, "DEPLOY_USER="+user)
+// Checkout fetches the remote, resolves ref to a commit hash and runs
+// either default checkout command or checkout_overwrite script.
+// The output is written to a log file like deploys, and the returned reader must be closed.
+func (p *Project) Checkout(ref string, user string) (io.ReadCloser, error) {
+	dir := workdir.ProjectDir(p.Name)
 
-	err := workdir.RotateLogs(p.Name)
+	// malformed refs fail before taking the run and the log, without fetching
+	err := gitutil.ValidateRef(dir, ref)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to rotate log files")
+		return nil, err
 	}
 
-	// write to log file
-	f, err := os.OpenFile(workdir.LogFile(p.Name, 0), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	meta, err := readMeta(p.Name)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to open log file")
-	}
-	callback := func() {
-		f.Close()
-		datadog.Deployed(p.Name, user, env)
-		hook.Deployed(p.Name, user, env)
+		return nil, err
 	}
-	r, err := stdoutStderrReader(cmd, callback)
+	config, err := readConfig(p.Name)
 	if err != nil {
-		f.Close()
 		return nil, err
 	}
 
-	r2 := io.TeeReader(r, f)
-	return r2, nil
-}
+	// empty when HEAD is unborn or broken, which checkout fixes
+	prevCommit, _ := gitutil.ResolveRef(dir, "HEAD")
 
-func stdoutStderrReader(cmd *exec.Cmd, callback func()) (io.Reader, error) {
-	// StdoutPipe returns a ReadCloser, but it's not meant to be Close()'ed by users
-	stdout, err := cmd.StdoutPipe()
+	run, err := startRun(p.Name)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get stdout pipe")
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get stderr pipe")
+		return nil, err
 	}
-	err = cmd.Start()
+
+	f, err := workdir.CreateRunLog(p.Name, RunCheckout, run.id)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to run command")
+		finishRun(p.Name)
+		return nil, err
 	}
+
+	pr, pw := io.Pipe()
+	out := newDeployLog(f, pw, redact.New(credentials.Secrets(p.Name)))
+	run.out = out
+
 	go func() {
-		cmd.Wait()
-		if callback != nil {
-			callback()
+		fetched, err := gitutil.Fetch(dir, credentials.GitEnv(p.Name))
+		commit := ""
+		if err == nil {
+			commit, err = gitutil.ResolveRef(dir, ref)
+		}
+		out.header(&LogRecord{
+			Kind:    RunCheckout,
+			Project: string(p.Name),
+			Ref:     ref,
+			User:    user,
+			Commit:  commit,
+			RunID:   run.id,
+		})
+
+		status := 1
+		if err != nil {
+			fmt.Fprintln(out, err)
+		} else {
+			fmt.Fprint(out, fetched)
+			fmt.Fprintf(out, "resolved %s to %s\n", ref, commit)
+
+			var cmd *exec.Cmd
+			script := dir + "/" + config.Scripts.Checkout
+			if fileExists(script) {
+				cmd = unbuffered.Command("bash", "-x", "-c", script)
+			} else {
+				cmd = checkoutCommand(meta.CloneOptions.Submodules)
+			}
+			cmd.Dir = dir
+			cmd.Env = scriptEnv(config, nil, []string{
+				"DEPLOY_PROJECT=" + string(p.Name),
+				"DEPLOY_USER=" + user,
+				"DEPLOY_REF=" + ref,
+				"DEPLOY_COMMIT=" + commit,
+				"DEPLOY_PREV_COMMIT=" + prevCommit,
+				"DEPLOY_LOG=" + workdir.RunLogFile(p.Name, RunCheckout, run.id),
+				"DEPLOY_RUN_ID=" + run.id,
+			})
+			cmd.Env = append(cmd.Env, credentials.GitEnv(p.Name)...)
+
+			err := streamCommand(cmd, out, streamOptions{})
+			if _, ok := err.(*exec.ExitError); err != nil && !ok {
+				fmt.Fprintln(out, err)
+			}
+			status = exitStatus(err)
 		}
+
+		out.footer(status)
+		finishRun(p.Name)
+		f.Close()
+		pw.Close()
 	}()
-	return io.MultiReader(stdout, stderr), nil
+
+	return pr, nil
 }
 
 func (p *Project) readReadme() error {
@@ -192,56 +320,54 @@ func (p *Project) readReadme() error {
 	return nil
 }
 
-func (p *Project) readDeployEnvs() error {
-	envsFile := workdir.ProjectDir(p.Name) + "/.deploy/config/deploy_envs"
+// readDeployEnvs reads the list of deploy environments from the deploy_envs file
+func readDeployEnvs(name workdir.Name) ([]string, error) {
+	envsFile := workdir.ProjectDir(name) + "/.deploy/config/deploy_envs"
 	envs := []string{"staging", "production"} // default
 	if fileExists(envsFile) {
 		b, err := ioutil.ReadFile(envsFile)
 		if err != nil {
-			return errors.Wrap(err, "failed reading file") // TODO: should panic?
+			return nil, errors.Wrap(err, "failed reading file") // TODO: should panic?
 		}
 		envs2 := removeEmpty(strings.Split(string(b), "\n"))
 		if len(envs2) != 0 {
 			envs = envs2
 		}
 	}
-	p.DeployEnvs = envs
-
-	return nil
+	return envs, nil
 }
 
-// LogReader returns a ReadCloser which reads either an entire file
-// or first 10000 bytes of it depending on the `full` parameter
-func (p *Project) LogReader(full bool, generation int) (io.ReadCloser, error) {
-	logFile := workdir.LogFile(p.Name, generation)
-	f, err := os.Open(logFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &EmptyReadCloser{}, nil
-		}
-		return nil, err
+// originURL returns the URL the project was cloned from.
+// Projects cloned before metadata was introduced fall back to the git config.
+func (p *Project) originURL() string {
+	meta, err := readMeta(p.Name)
+	if err == nil && meta.URL != "" {
+		return stripUserinfo(meta.URL)
 	}
-	if full {
-		return f, nil
+
+	cmd := exec.Command("git", "config", "--get", "remote.origin.url")
+	cmd.Dir = workdir.ProjectDir(p.Name)
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
 	}
-	return headreader.New(f, 10000), nil // first 10000 bytes
+	return stripUserinfo(strings.TrimSpace(string(out)))
 }
 
 // GetDefaultBranch returns default branch of repository
 func (p *Project) GetDefaultBranch() (string, error) {
 	cmd := exec.Command("git", "remote", "show", "origin")
 	cmd.Dir = workdir.ProjectDir(p.Name)
-	reader, err := stdoutStderrReader(cmd, nil)
-
+	cmd.Env = append(os.Environ(), credentials.GitEnv(p.Name)...)
+	out, err := cmd.Output()
 	if err != nil {
-		return "", err
+		return "", errors.Wrap(err, "failed to exec git command")
 	}
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(reader)
-	stdout := buf.String()
-	re := regexp.MustCompile("HEAD branch: (\\S+)")
-	group := re.FindStringSubmatch(stdout)
+	group := regexp.MustCompile("HEAD branch: (\\S+)").FindStringSubmatch(string(out))
+	if group == nil {
+		return "", errors.New("failed to determine default branch")
+	}
 
 	return group[1], nil
 }
@@ -249,7 +375,7 @@ func (p *Project) GetDefaultBranch() (string, error) {
 // GetCachedDefaultBranch returns cached default branch if exists.
 // GetCachedDefaultBranch returns the default branch from memory if cached, otherwise, compute and cache it.
 func (p *Project) GetCachedDefaultBranch() (string, error) {
-	cachedDefaultBranch := cache.DefaultBranch.Load(p.Name)
+	cachedDefaultBranch := cache.DefaultBranch.Load(string(p.Name))
 
 	if cachedDefaultBranch != "" {
 		// returns cached default branch
@@ -262,7 +388,7 @@ func (p *Project) GetCachedDefaultBranch() (string, error) {
 		return "", err
 	}
 
-	cache.DefaultBranch.Store(p.Name, defaultBranch)
+	cache.DefaultBranch.Store(string(p.Name), defaultBranch)
 
 	return defaultBranch, nil
 }
@@ -277,6 +403,16 @@ func (b *EmptyReadCloser) Close() error {
 	return nil
 }
 
+// collapseProgress keeps only the last update of each progress line, which git separates by carriage returns
+func collapseProgress(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		updates := strings.Split(strings.TrimRight(line, "\r"), "\r")
+		lines[i] = updates[len(updates)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
 func removeEmpty(a []string) (r []string) {
 	for _, s := range a {
 		if s != "" {
@@ -287,18 +423,23 @@ func removeEmpty(a []string) (r []string) {
 }
 
 // checkoutCommand is a better version of `git checkout` or `git pull`
-func checkoutCommand() *exec.Cmd {
-	script := strings.Join([]string{
-		"git fetch --prune",
-		"git checkout -f $DEPLOY_COMMIT",
-		"git reset --hard $DEPLOY_COMMIT",
+// the remote is expected to be fetched already and DEPLOY_COMMIT to be a resolved hash
+func checkoutCommand(submodules bool) *exec.Cmd {
+	commands := []string{
+		`git checkout -f "$DEPLOY_COMMIT"`,
+		`git reset --hard "$DEPLOY_COMMIT"`,
 		"git clean -fdx",
-		"git submodule sync",
-		"git submodule init",
-		"git submodule update --recursive",
-	}, " && ")
+	}
+	if submodules {
+		commands = append(commands,
+			"git submodule sync",
+			"git submodule init",
+			"git submodule update --recursive",
+		)
+	}
+	script := strings.Join(commands, " && ")
 
-	return unbuffered.Command("bash -x -c '" + script + "'")
+	return unbuffered.Command("bash", "-x", "-c", script)
 }
 
 func fileExists(filename string) bool {
@@ -0,0 +1,284 @@
+package project
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/edvakf/go-pploy/models/workdir"
+	"github.com/edvakf/go-pploy/unbuffered"
+	"github.com/pkg/errors"
+)
+
+// Markers which a deploy script prints at the beginning of a line on stdout to ask the lock holder.
+// The answer is written to the script's stdin followed by a newline.
+const (
+	confirmMarker = "::pploy-confirm::" // answered with "y" or "n"
+	inputMarker   = "::pploy-input::"   // answered with a line of text
+)
+
+// Prompt types
+const (
+	PromptConfirm = "confirm"
+	PromptInput   = "input"
+)
+
+// Prompt is a question asked by a running deploy script
+type Prompt struct {
+	ID      int    `json:"id"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// run is a checkout or a deploy running for a project
+type run struct {
+	id      string
+	mu      sync.Mutex
+	stdin   io.Writer
+	out     io.Writer
+	prompt  *Prompt
+	prompts int // number of prompts asked so far, used for IDs
+}
+
+// map of project name to the running deploy
+var runs = make(map[workdir.Name]*run)
+
+var runsMu sync.Mutex
+
+// startRun registers a checkout or a deploy of a project, and fails when another one is running,
+// so that the working tree is not changed while a deploy script is using it
+func startRun(name workdir.Name) (*run, error) {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	if _, ok := runs[name]; ok {
+		return nil, errors.New("another checkout or deploy is running for the project")
+	}
+	r := &run{id: newRunID()}
+	runs[name] = r
+	return r, nil
+}
+
+func finishRun(name workdir.Name) {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	delete(runs, name)
+}
+
+func findRun(name workdir.Name) *run {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	return runs[name]
+}
+
+// newRunID returns a unique ID of a checkout or a deploy, which sorts by the start time
+func newRunID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// handleLine turns marker lines into prompts
+func (r *run) handleLine(stream string, line string) (string, bool) {
+	if stream != StreamStdout {
+		return line, true
+	}
+	var typ, message string
+	if strings.HasPrefix(line, confirmMarker) {
+		typ, message = PromptConfirm, strings.TrimPrefix(line, confirmMarker)
+	} else if strings.HasPrefix(line, inputMarker) {
+		typ, message = PromptInput, strings.TrimPrefix(line, inputMarker)
+	} else {
+		return line, true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stdin == nil {
+		return fmt.Sprintf("? %s (not asked since the deploy is not interactive)", message), true
+	}
+	r.prompts++
+	r.prompt = &Prompt{ID: r.prompts, Type: typ, Message: message}
+	return fmt.Sprintf("? %s (waiting for the answer of the lock holder)", message), true
+}
+
+// setStdin switches the stdin answers are written to, since each phase of a deploy runs a new process.
+// Prompts are not asked while it is nil.
+func (r *run) setStdin(stdin io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stdin = stdin
+	r.prompt = nil
+}
+
+func (r *run) currentPrompt() *Prompt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.prompt
+}
+
+func (r *run) answer(id int, answer string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.prompt == nil || r.prompt.ID != id {
+		return errors.New("the prompt is already answered")
+	}
+	if r.prompt.Type == PromptConfirm {
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			answer = "y"
+		case "n", "no", "":
+			answer = "n"
+		default:
+			return errors.Errorf("answer %q is not y or n", answer)
+		}
+	}
+	if strings.ContainsAny(answer, "\r\n") {
+		return errors.New("answer must be a single line")
+	}
+
+	_, err := io.WriteString(r.stdin, answer+"\n")
+	if err != nil {
+		return errors.Wrap(err, "failed to write to the deploy script")
+	}
+	r.prompt = nil
+	fmt.Fprintf(r.out, "> %s\n", answer)
+	return nil
+}
+
+// CurrentPrompt returns the question asked by the running deploy of the project, or nil
+func (p *Project) CurrentPrompt() *Prompt {
+	r := findRun(p.Name)
+	if r == nil {
+		return nil
+	}
+	return r.currentPrompt()
+}
+
+// AnswerPrompt writes the answer to the stdin of the running deploy
+func (p *Project) AnswerPrompt(id int, answer string) error {
+	r := findRun(p.Name)
+	if r == nil {
+		return errors.New("deploy is not running")
+	}
+	return r.answer(id, answer)
+}
+
+// syncWriter serializes writes from multiple goroutines, so that lines are not mixed up
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newSyncWriter(w io.Writer) *syncWriter {
+	return &syncWriter{w: w}
+}
+
+// Write implements the io.Writer interface
+func (sw *syncWriter) Write(b []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	return sw.w.Write(b)
+}
+
+// lineHandler is called for each line of output. It returns the line to be written, or false to drop the line.
+type lineHandler func(stream string, line string) (string, bool)
+
+// streamOptions are optional behaviors of streamCommand
+type streamOptions struct {
+	onLine  lineHandler
+	timeout time.Duration // 0 means no limit
+	limits  Limits
+}
+
+// TimeoutError is returned by streamCommand when the command was killed for exceeding its timeout
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+// how long to wait for the output pipes to be closed after the process group is killed,
+// since processes which left the group can keep them open
+var killGracePeriod = 5 * time.Second
+
+// streamCommand runs cmd in a new process group writing its output to w line by line as they come,
+// and returns the error of cmd.Wait(). w must be safe for concurrent use, and gets the stream of each line if it is a lineWriter.
+func streamCommand(cmd *exec.Cmd, w io.Writer, opts streamOptions) error {
+	setProcessGroup(cmd)
+	err := limitCommand(cmd, opts.limits)
+	if err != nil {
+		writeLine(w, StreamPploy, "warning: "+err.Error())
+	}
+
+	out, err := unbuffered.Start(cmd)
+	if err != nil {
+		return errors.Wrap(err, "failed to run command")
+	}
+	defer out.Close()
+
+	var timedOut int32
+	if opts.timeout > 0 {
+		timer := time.AfterFunc(opts.timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			killProcessGroup(cmd)
+			time.AfterFunc(killGracePeriod, func() {
+				out.Close()
+			})
+		})
+		defer timer.Stop()
+	}
+
+	var wg sync.WaitGroup
+	scan := func(stream string, r io.Reader) {
+		defer wg.Done()
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				// a pty turns "\n" into "\r\n"
+				line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+				ok := true
+				if opts.onLine != nil {
+					line, ok = opts.onLine(stream, line)
+				}
+				if ok {
+					writeLine(w, stream, line)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(1)
+	go scan(StreamStdout, out.Stdout)
+	if out.Stderr != nil {
+		wg.Add(1)
+		go scan(StreamStderr, out.Stderr)
+	}
+	// output must be read to the end before calling Wait
+	wg.Wait()
+
+	err = cmd.Wait()
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return &TimeoutError{Timeout: opts.timeout}
+	}
+	return err
+}
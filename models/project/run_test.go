@@ -0,0 +1,21 @@
+package project
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewRunID(t *testing.T) {
+	id := newRunID()
+	started, err := time.Parse("20060102-150405", id[:15])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// parsed as UTC, so it is off by the local offset unless the ID is in UTC
+	if d := time.Since(started); d < -time.Second || d > time.Minute {
+		t.Errorf("run ID %s is not the current time in UTC", id)
+	}
+	if len(id) != 22 {
+		t.Errorf("unexpected run ID %s", id)
+	}
+}
@@ -0,0 +1,260 @@
+package project
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edvakf/go-pploy/models/workdir"
+	"github.com/pkg/errors"
+)
+
+// Limits of log search results
+const (
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 1000
+	MaxSearchContext   = 10
+)
+
+// SearchOptions are options of SearchLogs
+type SearchOptions struct {
+	Query   string
+	Regexp  bool // Query is a regexp, otherwise a substring
+	Context int  // number of lines before and after matched lines
+	Limit   int  // max number of matched lines
+}
+
+// LogInfo is the metadata of the log of a deploy or a checkout
+type LogInfo struct {
+	RunID      string    `json:"runId"`
+	Kind       string    `json:"kind"`
+	Env        string    `json:"env"` // deploy
+	Ref        string    `json:"ref"` // checkout
+	User       string    `json:"user"`
+	Commit     string    `json:"commit"`
+	ExitStatus *int      `json:"exitStatus"` // nil while running
+	Time       time.Time `json:"time"`       // start of the run, or the last write for logs in the text format
+}
+
+// LogMatch is a line of a deploy log matched by SearchLogs
+type LogMatch struct {
+	Project    string   `json:"project"`
+	RunID      string   `json:"runId"`
+	Generation int      `json:"generation"` // 0 for the latest run of the kind of the project
+	Run        LogInfo  `json:"run"`
+	LineNumber int      `json:"lineNumber"` // 1-origin line number of the log rendered as plain text
+	Line       string   `json:"line"`
+	Before     []string `json:"before"`
+	After      []string `json:"after"`
+}
+
+var textHeaderPattern = regexp.MustCompile(`^# deploy to (\S+) by (.*)$`)
+var textCheckoutPattern = regexp.MustCompile(`^# checkout (\S+) by (.*)$`)
+var textCommitPattern = regexp.MustCompile(`^# commit: (\S+)$`)
+var textFooterPattern = regexp.MustCompile(`^# finished with exit status (\d+) in `)
+
+// SearchLogs searches all deploy and checkout logs of the projects, newest runs first.
+// It returns true when there are more matches than the limit.
+func SearchLogs(names []workdir.Name, opts SearchOptions) ([]LogMatch, bool, error) {
+	if opts.Query == "" {
+		return nil, false, errors.New("query is empty")
+	}
+	match := func(line string) bool {
+		return strings.Contains(line, opts.Query)
+	}
+	if opts.Regexp {
+		re, err := regexp.Compile(opts.Query)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "invalid regexp")
+		}
+		match = re.MatchString
+	}
+	if opts.Limit <= 0 || opts.Limit > MaxSearchLimit {
+		opts.Limit = DefaultSearchLimit
+	}
+	if opts.Context < 0 {
+		opts.Context = 0
+	}
+	if opts.Context > MaxSearchContext {
+		opts.Context = MaxSearchContext
+	}
+
+	type log struct {
+		project    *Project
+		generation int
+		info       LogInfo
+	}
+	logs := []log{}
+	for _, name := range names {
+		p := &Project{Name: name}
+		for _, kind := range []string{RunDeploy, RunCheckout} {
+			ids, err := p.RunIDs(kind)
+			if err != nil {
+				return nil, false, err
+			}
+			for gen, id := range ids {
+				info, err := p.LogInfo(id)
+				if err != nil {
+					return nil, false, err
+				}
+				if info != nil {
+					logs = append(logs, log{p, gen, *info})
+				}
+			}
+		}
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].info.Time.After(logs[j].info.Time)
+	})
+
+	matches := []LogMatch{}
+	for _, l := range logs {
+		var truncated bool
+		var err error
+		matches, truncated, err = searchLog(l.project, l.info, l.generation, match, opts, matches)
+		if err != nil || truncated {
+			return matches, truncated, err
+		}
+	}
+	return matches, false, nil
+}
+
+// maxSearchLineBytes is the longest line searched as a whole. Longer lines are split, and counted as multiple lines.
+const maxSearchLineBytes = 1024 * 1024
+
+// searchLog scans a log line by line appending matches, and returns true when it finds more matches than the limit
+func searchLog(p *Project, info LogInfo, generation int, match func(string) bool, opts SearchOptions, matches []LogMatch) ([]LogMatch, bool, error) {
+	r, err := openLogStream(p.Name, info.RunID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return matches, false, nil
+		}
+		return nil, false, errors.Wrap(err, "failed to open log file")
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxSearchLineBytes)
+	scanner.Split(scanLongLines)
+	before := []string{} // last lines up to opts.Context
+	waiting := []int{}   // indexes of matches waiting for lines after them
+	truncated := false
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+
+		rest := waiting[:0]
+		for _, i := range waiting {
+			matches[i].After = append(matches[i].After, line)
+			if len(matches[i].After) < opts.Context {
+				rest = append(rest, i)
+			}
+		}
+		waiting = rest
+
+		if !truncated && match(line) {
+			if len(matches) == opts.Limit {
+				// lines after the matches so far are still read
+				truncated = true
+			} else {
+				matches = append(matches, LogMatch{
+					Project:    string(p.Name),
+					RunID:      info.RunID,
+					Generation: generation,
+					Run:        info,
+					LineNumber: n,
+					Line:       line,
+					Before:     append([]string{}, before...),
+					After:      []string{},
+				})
+				if opts.Context > 0 {
+					waiting = append(waiting, len(matches)-1)
+				}
+			}
+		}
+		if truncated && len(waiting) == 0 {
+			break
+		}
+
+		before = append(before, line)
+		if len(before) > opts.Context {
+			before = before[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, errors.Wrap(err, "failed to read log file")
+	}
+	return matches, truncated, nil
+}
+
+// scanLongLines is bufio.ScanLines which splits lines longer than maxSearchLineBytes instead of failing
+func scanLongLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= maxSearchLineBytes {
+		return len(data), data, nil
+	}
+	return advance, token, err
+}
+
+// LogInfo reads the metadata of the log file of a run, or returns nil when it does not exist
+func (p *Project) LogInfo(runID string) (*LogInfo, error) {
+	f, err := openLogFile(p.Name, runID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to open log file")
+	}
+	defer f.Close()
+
+	info := &LogInfo{RunID: runID, Kind: RunDeploy, Time: f.modTime}
+	isJSON := isJSONLog(f)
+	scanner := bufio.NewScanner(f)
+	if isJSON {
+		if scanner.Scan() {
+			r := &LogRecord{}
+			if json.Unmarshal(scanner.Bytes(), r) == nil && r.Type == recordHeader {
+				info = &LogInfo{RunID: runID, Kind: r.Kind, Env: r.Env, Ref: r.Ref, User: r.User, Commit: r.Commit, Time: r.Time}
+				if info.Kind == "" {
+					info.Kind = RunDeploy
+				}
+			}
+		}
+	} else {
+		// the header of text logs is the first few lines
+		for i := 0; i < 3 && scanner.Scan(); i++ {
+			if m := textHeaderPattern.FindStringSubmatch(scanner.Text()); m != nil {
+				info.Env, info.User = m[1], m[2]
+			} else if m := textCheckoutPattern.FindStringSubmatch(scanner.Text()); m != nil {
+				info.Kind, info.Ref, info.User = RunCheckout, m[1], m[2]
+			} else if m := textCommitPattern.FindStringSubmatch(scanner.Text()); m != nil {
+				info.Commit = m[1]
+			}
+		}
+	}
+
+	// the footer is the last line
+	offset, err := tailOffset(f, f.size, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read log file")
+	}
+	last := make([]byte, f.size-offset)
+	_, err = f.ReadAt(last, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read log file")
+	}
+	if isJSON {
+		r := &LogRecord{}
+		if json.Unmarshal(last, r) == nil && r.Type == recordFooter {
+			info.ExitStatus = r.ExitStatus
+		}
+	} else if m := textFooterPattern.FindSubmatch(last); m != nil {
+		status, _ := strconv.Atoi(string(m[1]))
+		info.ExitStatus = &status
+	}
+	return info, nil
+}
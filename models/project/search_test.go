@@ -0,0 +1,105 @@
+package project
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edvakf/go-pploy/models/workdir"
+)
+
+func TestSearchLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workdir.Init(dir)
+
+	os.MkdirAll(workdir.ProjectLogsDir("foo"), 0755)
+	ioutil.WriteFile(workdir.RunLogFile("foo", RunDeploy, "20200101-000000-aaaaaa"), []byte("# deploy to staging by alice\na\nmigration failed\nb\n# finished with exit status 1 in 0.5s\n"), 0644)
+	ioutil.WriteFile(workdir.RunLogFile("foo", RunDeploy, "21000101-000000-bbbbbb"), []byte(`{"type":"header","time":"2100-01-01T00:00:00Z","env":"production","user":"bob"}
+{"type":"line","line":"migration failed: timeout"}
+`), 0644)
+
+	matches, truncated, err := SearchLogs([]workdir.Name{"foo"}, SearchOptions{Query: "migration failed", Context: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated || len(matches) != 2 {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+	if m := matches[0]; m.RunID != "21000101-000000-bbbbbb" || m.Generation != 0 || m.Run.User != "bob" || m.LineNumber != 2 || len(m.Before) != 1 || len(m.After) != 0 {
+		t.Errorf("unexpected match %+v", m)
+	}
+	if m := matches[1]; m.Generation != 1 || m.Run.Env != "staging" || m.Run.ExitStatus == nil || *m.Run.ExitStatus != 1 || m.Before[0] != "a" || m.After[0] != "b" {
+		t.Errorf("unexpected match %+v", m)
+	}
+
+	matches, truncated, err = SearchLogs([]workdir.Name{"foo"}, SearchOptions{Query: `^migration failed$`, Regexp: true, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated || len(matches) != 1 || matches[0].Generation != 1 {
+		t.Errorf("unexpected matches %v", matches)
+	}
+
+	// checkouts are kept apart, and do not count as generations of deploys
+	os.MkdirAll(workdir.RunLogsDir("foo", RunCheckout), 0755)
+	ioutil.WriteFile(workdir.RunLogFile("foo", RunCheckout, "20500101-000000-cccccc"), []byte("# checkout master by carol\n# commit: abc\n"), 0644)
+	p := &Project{Name: "foo"}
+	info, err := p.LogInfo("20500101-000000-cccccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Kind != RunCheckout || info.Ref != "master" || info.User != "carol" || info.Commit != "abc" || info.ExitStatus != nil {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if id, _ := p.RunIDOfGeneration(1); id != "20200101-000000-aaaaaa" {
+		t.Errorf("unexpected run ID of generation 1 %s", id)
+	}
+	if ids, _ := p.RunIDs(RunDeploy, RunCheckout); len(ids) != 3 || ids[1] != "20500101-000000-cccccc" {
+		t.Errorf("unexpected run IDs %v", ids)
+	}
+	matches, _, err = SearchLogs([]workdir.Name{"foo"}, SearchOptions{Query: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].RunID != "20500101-000000-cccccc" || matches[0].Generation != 0 {
+		t.Errorf("unexpected matches %v", matches)
+	}
+}
+
+func TestSearchLogsLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workdir.Init(dir)
+
+	// a gzipped log with a line longer than the scanner buffer
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte("# deploy to staging by alice\n" + strings.Repeat("x", maxSearchLineBytes+10) + "\nerror 1\na\nerror 2\nb\nc\nerror 3\n"))
+	zw.Close()
+	os.MkdirAll(workdir.ProjectLogsDir("foo"), 0755)
+	ioutil.WriteFile(workdir.RunLogFile("foo", RunDeploy, "20200101-000000-aaaaaa")+".gz", b.Bytes(), 0644)
+
+	matches, truncated, err := SearchLogs([]workdir.Name{"foo"}, SearchOptions{Query: "error", Context: 2, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated || len(matches) != 2 {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+	if m := matches[1]; m.Line != "error 2" || strings.Join(m.Before, ",") != "error 1,a" || strings.Join(m.After, ",") != "b,c" || m.Run.Env != "staging" {
+		t.Errorf("unexpected match %+v", m)
+	}
+	if m := matches[0]; strings.Join(m.After, ",") != "a,error 2" {
+		t.Errorf("unexpected match %+v", m)
+	}
+}
@@ -0,0 +1,120 @@
+package redact
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Mask replaces secrets in logs
+const Mask = "***"
+
+// MinSecretLength is the length of the shortest literal secret which is masked.
+// Shorter ones would mask too much of logs, like every "a" for a secret "a".
+const MinSecretLength = 4
+
+// DefaultPatterns match common credentials. When a pattern has groups, only the groups are masked.
+var DefaultPatterns = []string{
+	`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`,                                           // AWS access key ID
+	`(?i)aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`, // AWS secret access key
+	`(?i)\bbearer\s+([A-Za-z0-9._~+/-]+=*)`,                                     // bearer tokens
+	`\b[A-Za-z][A-Za-z0-9+.-]*://[^/\s:@]*:([^/\s@]+)@`,                         // passwords in URLs
+}
+
+// literals and patterns masked by all filters
+var literals []string
+var patterns []*regexp.Regexp
+
+// Configure sets literal secrets and regexp patterns which are masked by all filters
+func Configure(lits []string, pats []string) error {
+	compiled := []*regexp.Regexp{}
+	for _, p := range pats {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", p)
+		}
+		compiled = append(compiled, re)
+	}
+	literals = lits
+	patterns = compiled
+	return nil
+}
+
+// Filter replaces secrets in text with Mask
+type Filter struct {
+	replacer *strings.Replacer
+	patterns []*regexp.Regexp
+}
+
+// New creates a Filter which replaces the literal secrets in addition to the configured ones.
+// Secrets shorter than MinSecretLength are ignored.
+func New(secrets []string) *Filter {
+	// longer ones first, so that a secret containing another one is masked as a whole
+	sorted := append(append([]string{}, literals...), secrets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	oldnew := []string{}
+	for _, s := range sorted {
+		if len(s) >= MinSecretLength {
+			oldnew = append(oldnew, s, Mask)
+		}
+	}
+	return &Filter{
+		replacer: strings.NewReplacer(oldnew...),
+		patterns: patterns,
+	}
+}
+
+// String returns s with secrets masked
+func (f *Filter) String(s string) string {
+	s = f.replacer.Replace(s)
+	for _, re := range f.patterns {
+		s = maskPattern(re, s)
+	}
+	return s
+}
+
+// maskPattern masks the groups of matches, or whole matches when the pattern has no groups
+func maskPattern(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(s, Mask)
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		for i := 2; i < len(m); i += 2 {
+			if m[i] < last { // unmatched (-1) or nested groups
+				continue
+			}
+			b.WriteString(s[last:m[i]])
+			b.WriteString(Mask)
+			last = m[i+1]
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// Writer returns a Writer which masks secrets before writing to w.
+// Secrets split across writes are not masked, so each write should be a whole line.
+func (f *Filter) Writer(w io.Writer) io.Writer {
+	return &writer{f: f, w: w}
+}
+
+type writer struct {
+	f *Filter
+	w io.Writer
+}
+
+// Write implements the io.Writer interface
+func (w *writer) Write(b []byte) (int, error) {
+	_, err := io.WriteString(w.w, w.f.String(string(b)))
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,223 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/edvakf/go-pploy/models/workdir"
+	"github.com/pkg/errors"
+)
+
+// Secret is a secret without its value
+type Secret struct {
+	Name string `json:"name"`
+	Env  string `json:"env"` // empty for all deploy environments
+}
+
+// store is the plain text of a secrets file, mapping env to name to value
+type store map[string]map[string]string
+
+var key []byte
+
+// serializes read-modify-write of secrets files
+var mu sync.Mutex
+
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SetMasterKey sets the key which secrets files are encrypted with. Secrets are disabled when it is empty.
+func SetMasterKey(masterKey string) {
+	if masterKey == "" {
+		key = nil
+		return
+	}
+	sum := sha256.Sum256([]byte(masterKey))
+	key = sum[:]
+}
+
+// Enabled returns true when the master key is set
+func Enabled() bool {
+	return key != nil
+}
+
+// List returns secrets of a project sorted by env and name
+func List(project workdir.Name) ([]Secret, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, err := read(project)
+	if err != nil {
+		return nil, err
+	}
+	a := []Secret{}
+	for env, values := range s {
+		for name := range values {
+			a = append(a, Secret{Name: name, Env: env})
+		}
+	}
+	sort.Slice(a, func(i, j int) bool {
+		if a[i].Env != a[j].Env {
+			return a[i].Env < a[j].Env
+		}
+		return a[i].Name < a[j].Name
+	})
+	return a, nil
+}
+
+// Set stores a secret of a project, which is passed to deploys to env (or all envs when empty)
+// as an environment variable of the name
+func Set(project workdir.Name, env string, name string, value string) error {
+	if !namePattern.MatchString(name) {
+		return errors.Errorf("invalid secret name %q (use letters, digits and '_')", name)
+	}
+	if strings.HasPrefix(name, "DEPLOY_") {
+		return errors.New("DEPLOY_ variables are reserved")
+	}
+	if value == "" {
+		return errors.New("secret value is empty")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, err := read(project)
+	if err != nil {
+		return err
+	}
+	if s[env] == nil {
+		s[env] = map[string]string{}
+	}
+	s[env][name] = value
+	return write(project, s)
+}
+
+// Delete removes a secret of a project
+func Delete(project workdir.Name, env string, name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, err := read(project)
+	if err != nil {
+		return err
+	}
+	if _, ok := s[env][name]; !ok {
+		return errors.Errorf("secret %s does not exist", name)
+	}
+	delete(s[env], name)
+	if len(s[env]) == 0 {
+		delete(s, env)
+	}
+	return write(project, s)
+}
+
+// ForEnv returns secrets of a project for a deploy to env, where secrets of the env override the ones for all envs
+func ForEnv(project workdir.Name, env string) (map[string]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !Enabled() && !exists(project) {
+		return map[string]string{}, nil
+	}
+	s, err := read(project)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for name, value := range s[""] {
+		values[name] = value
+	}
+	if env != "" {
+		for name, value := range s[env] {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+func exists(project workdir.Name) bool {
+	_, err := os.Stat(workdir.SecretsFile(project))
+	return err == nil
+}
+
+func read(project workdir.Name) (store, error) {
+	if !Enabled() {
+		return nil, errors.New("secrets are disabled, set the master key to use them")
+	}
+	b, err := ioutil.ReadFile(workdir.SecretsFile(project))
+	if os.IsNotExist(err) {
+		return store{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read secrets")
+	}
+
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, errors.New("secrets file is broken")
+	}
+	// the project name is authenticated, so that a file copied to another project can't be decrypted
+	plain, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], []byte(project))
+	if err != nil {
+		return nil, errors.New("failed to decrypt secrets, the master key may be wrong")
+	}
+
+	s := store{}
+	err = json.Unmarshal(plain, &s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse secrets")
+	}
+	return s, nil
+}
+
+func write(project workdir.Name, s store) error {
+	plain, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode secrets")
+	}
+
+	gcm, err := newGCM()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate nonce")
+	}
+	b := gcm.Seal(nonce, nonce, plain, []byte(project))
+
+	// write to a temporary file and rename it, so that a crash does not leave a broken file
+	file := workdir.SecretsFile(project)
+	err = ioutil.WriteFile(file+".tmp", b, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write secrets")
+	}
+	err = os.Rename(file+".tmp", file)
+	if err != nil {
+		return errors.Wrap(err, "failed to write secrets")
+	}
+	return nil
+}
+
+func newGCM() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+	return gcm, nil
+}
@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edvakf/go-pploy/models/workdir"
+)
+
+func TestSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workdir.Init(dir)
+	SetMasterKey("0123456789abcdef")
+
+	if err := Set("foo", "", "TOKEN", "common"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set("foo", "production", "TOKEN", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set("foo", "", "DEPLOY_ENV", "x"); err == nil {
+		t.Error("expected error for a reserved name")
+	}
+
+	values, err := ForEnv("foo", "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["TOKEN"] != "prod" {
+		t.Errorf("unexpected values %v", values)
+	}
+	values, err = ForEnv("foo", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["TOKEN"] != "common" {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	b, err := ioutil.ReadFile(workdir.SecretsFile("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{"TOKEN", "common", "prod"} {
+		if strings.Contains(string(b), plain) {
+			t.Errorf("%s is stored in plain text", plain)
+		}
+	}
+
+	// a file copied to another project can't be decrypted
+	ioutil.WriteFile(workdir.SecretsFile("bar"), b, 0600)
+	if _, err := ForEnv("bar", ""); err == nil {
+		t.Error("expected error for a file of another project")
+	}
+
+	SetMasterKey("another key 0123")
+	if _, err := ForEnv("foo", ""); err == nil {
+		t.Error("expected error for a wrong key")
+	}
+	SetMasterKey("0123456789abcdef")
+
+	if err := Delete("foo", "production", "TOKEN"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := List("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0] != (Secret{Name: "TOKEN"}) {
+		t.Errorf("unexpected list %v", list)
+	}
+}
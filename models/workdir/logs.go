@@ -0,0 +1,328 @@
+package workdir
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Retention policy of log files. The latest log of each kind of runs of each project is always kept.
+var (
+	LogMax      int           // max number of previous logs of each kind to keep
+	LogMaxAge   time.Duration // logs older than this are removed, 0 means no limit
+	LogMaxBytes int64         // max total size of logs of all kinds of each project, 0 means no limit
+	LogGzip     bool          // compress previous logs, including those written before it is set
+)
+
+const gzipExt = ".gz"
+
+// serializes creation and cleaning of log files
+var logsMu sync.Mutex
+
+// run IDs look like 20060102-150405-0a1b2c in UTC, so that they sort by time
+var runIDPattern = regexp.MustCompile(`^\d{8}-\d{6}-[0-9a-f]{6}$`)
+
+// <run ID>.log or <run ID>.log.gz
+var runLogPattern = regexp.MustCompile(`^(\d{8}-\d{6}-[0-9a-f]{6})\.log(\.gz)?$`)
+
+// <name>.log, <name>.log.<generation> or <name>.log.<generation>.gz written by older versions
+var legacyLogPattern = regexp.MustCompile(`^(.+)\.log(?:\.(\d+))?(\.gz)?$`)
+
+// logEntry is a log file of a run
+type logEntry struct {
+	kind    string
+	path    string
+	runID   string
+	size    int64
+	modTime time.Time
+}
+
+// ValidRunID returns true when id can be used to build the path of a log file
+func ValidRunID(id string) bool {
+	return runIDPattern.MatchString(id)
+}
+
+// CreateRunLog creates the log file of a new run of a kind of a project. Previous logs of the kind
+// are compressed when LogGzip is set, and logs exceeding the retention policy are removed.
+func CreateRunLog(name Name, kind string, runID string) (*os.File, error) {
+	if !ValidRunID(runID) {
+		return nil, errors.Errorf("invalid run ID %q", runID)
+	}
+
+	logsMu.Lock()
+	defer logsMu.Unlock()
+
+	err := os.MkdirAll(RunLogsDir(name, kind), os.ModePerm)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create log directory")
+	}
+	f, err := os.OpenFile(RunLogFile(name, kind, runID), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open log file")
+	}
+
+	err = removeExpiredLogs(name, time.Now())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// FindRunLog returns the path of the log file of a run of any kind, which is gzipped when the second value is true.
+// The path of the plain file of a deploy is returned when none exists.
+func FindRunLog(name Name, runID string) (string, bool) {
+	for _, kind := range []string{KindDeploy, KindCheckout} {
+		path := RunLogFile(name, kind, runID)
+		if _, err := os.Stat(path); err == nil {
+			return path, false
+		}
+		if _, err := os.Stat(path + gzipExt); err == nil {
+			return path + gzipExt, true
+		}
+	}
+	return RunLogFile(name, KindDeploy, runID), false
+}
+
+// RunIDs returns IDs of the runs of the kinds of a project which have log files, newest first
+func RunIDs(name Name, kinds ...string) ([]string, error) {
+	entries, err := listRunLogs(name, kinds...)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, e := range entries {
+		ids = append(ids, e.runID)
+	}
+	return ids, nil
+}
+
+// CleanLogs compresses and removes log files by the retention policy, and removes log files of projects which no longer exist
+func CleanLogs() error {
+	logsMu.Lock()
+	defer logsMu.Unlock()
+
+	dirs, err := ioutil.ReadDir(LogsDir())
+	if err != nil {
+		return errors.Wrap(err, "failed to list log files")
+	}
+	now := time.Now()
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		name := Name(d.Name())
+		if _, err := os.Stat(ProjectDir(name)); os.IsNotExist(err) {
+			err := os.RemoveAll(ProjectLogsDir(name))
+			if err != nil {
+				return errors.Wrap(err, "failed to delete log files")
+			}
+			continue
+		}
+		err := removeExpiredLogs(name, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartLogJanitor runs CleanLogs periodically in background
+func StartLogJanitor(interval time.Duration) {
+	go func() {
+		for {
+			err := CleanLogs()
+			if err != nil {
+				log.Printf("failed to clean logs: %s", err.Error())
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// listRunLogs returns log files of runs of the kinds of a project, newest first
+func listRunLogs(name Name, kinds ...string) ([]logEntry, error) {
+	entries := []logEntry{}
+	for _, kind := range kinds {
+		dir := RunLogsDir(name, kind)
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list log files")
+		}
+		for _, f := range files {
+			m := runLogPattern.FindStringSubmatch(f.Name())
+			if m == nil || f.IsDir() {
+				continue
+			}
+			entries = append(entries, logEntry{
+				kind:    kind,
+				path:    dir + "/" + f.Name(),
+				runID:   m[1],
+				size:    f.Size(),
+				modTime: f.ModTime(),
+			})
+		}
+	}
+	// run IDs started within the same second are ordered by the modification time
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.runID[:15] != b.runID[:15] {
+			return a.runID > b.runID
+		}
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.After(b.modTime)
+		}
+		return a.runID > b.runID
+	})
+	return entries, nil
+}
+
+// removeExpiredLogs removes logs of a project exceeding the retention policy, except for the latest one of each kind.
+// Once a log of a kind expires, all older ones of the kind are removed too.
+// The other logs except for the latest ones are compressed when LogGzip is set.
+func removeExpiredLogs(name Name, now time.Time) error {
+	entries, err := listRunLogs(name, KindDeploy, KindCheckout)
+	if err != nil {
+		return err
+	}
+
+	var total int64 // of the logs kept so far
+	count := map[string]int{}
+	expired := map[string]bool{}
+	for i := range entries {
+		e := &entries[i]
+		n := count[e.kind]
+		count[e.kind]++
+		if n != 0 && !expired[e.kind] {
+			expired[e.kind] = n > LogMax ||
+				(LogMaxAge > 0 && now.Sub(e.modTime) > LogMaxAge)
+		}
+		if n != 0 && !expired[e.kind] && LogGzip && !strings.HasSuffix(e.path, gzipExt) {
+			err := gzipEntry(e)
+			if err != nil {
+				return err
+			}
+		}
+		if n != 0 && !expired[e.kind] {
+			expired[e.kind] = LogMaxBytes > 0 && total+e.size > LogMaxBytes
+		}
+		if expired[e.kind] {
+			err := os.Remove(e.path)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "failed to delete log file")
+			}
+			continue
+		}
+		total += e.size
+	}
+	return nil
+}
+
+// removeLogs removes all log files of a project
+func removeLogs(name Name) error {
+	logsMu.Lock()
+	defer logsMu.Unlock()
+
+	err := os.RemoveAll(ProjectLogsDir(name))
+	if err != nil {
+		return errors.Wrap(err, "failed to delete log files")
+	}
+	return nil
+}
+
+// migrateLegacyLogs moves log files named by generations into per-run log files,
+// whose IDs are made from their modification time and generation.
+// The suffix counts down from ffffff, so that newer generations sort first within a second.
+func migrateLegacyLogs() {
+	files, err := ioutil.ReadDir(LogsDir())
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		m := legacyLogPattern.FindStringSubmatch(f.Name())
+		if m == nil || f.IsDir() {
+			continue
+		}
+		name, err := ParseName(m[1])
+		if err != nil {
+			continue
+		}
+		gen, _ := strconv.Atoi(m[2])
+		runID := fmt.Sprintf("%s-%06x", f.ModTime().UTC().Format("20060102-150405"), 0xffffff-gen)
+
+		err = os.MkdirAll(ProjectLogsDir(name), os.ModePerm)
+		if err == nil {
+			err = os.Rename(LogsDir()+"/"+f.Name(), RunLogFile(name, KindDeploy, runID)+m[3])
+		}
+		if err != nil {
+			log.Printf("failed to migrate log file %s: %s", f.Name(), err.Error())
+		}
+	}
+}
+
+// gzipEntry compresses a log file and updates the entry
+func gzipEntry(e *logEntry) error {
+	err := gzipFile(e.path)
+	if err != nil {
+		return err
+	}
+	e.path += gzipExt
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress log file")
+	}
+	e.size = fi.Size()
+	return nil
+}
+
+// gzipFile compresses a file into path.gz keeping its modification time, and removes the original
+func gzipFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+
+	tmp := path + gzipExt + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "failed to create compressed log file")
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, f)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to compress log file")
+	}
+
+	os.Chtimes(tmp, fi.ModTime(), fi.ModTime())
+	err = os.Rename(tmp, path+gzipExt)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress log file")
+	}
+	return os.Remove(path)
+}
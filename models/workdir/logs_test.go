@@ -0,0 +1,170 @@
+package workdir
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateRunLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Init(dir)
+	os.MkdirAll(ProjectDir("foo"), 0755)
+	LogMax, LogMaxAge, LogMaxBytes, LogGzip = 3, 0, 0, true
+	defer func() { LogMax, LogMaxAge, LogMaxBytes, LogGzip = 0, 0, 0, false }()
+
+	for i := 0; i < 5; i++ {
+		f, err := CreateRunLog("foo", KindDeploy, fmt.Sprintf("20200101-00000%d-aaaaaa", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(strings.Repeat("x", 100))
+		f.Close()
+	}
+	if files := logFileNames(t, "foo"); files != "20200101-000001-aaaaaa.log.gz 20200101-000002-aaaaaa.log.gz 20200101-000003-aaaaaa.log.gz 20200101-000004-aaaaaa.log" {
+		t.Errorf("unexpected files %s", files)
+	}
+	if path, gzipped := FindRunLog("foo", "20200101-000002-aaaaaa"); !gzipped || !strings.HasSuffix(path, ".log.gz") {
+		t.Errorf("unexpected path %s", path)
+	}
+	if ids, _ := RunIDs("foo", KindDeploy); len(ids) != 4 || ids[0] != "20200101-000004-aaaaaa" {
+		t.Errorf("unexpected run IDs %v", ids)
+	}
+	if _, err := CreateRunLog("foo", KindDeploy, "../bar"); err == nil {
+		t.Error("invalid run ID is accepted")
+	}
+
+	// logs of removed projects and older than max age are removed
+	os.MkdirAll(ProjectLogsDir("bar"), 0755)
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(RunLogFile("foo", KindDeploy, "20200101-000002-aaaaaa")+gzipExt, old, old)
+	LogMaxAge = 24 * time.Hour
+	err = CleanLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := logFileNames(t, "foo"); files != "20200101-000003-aaaaaa.log.gz 20200101-000004-aaaaaa.log" {
+		t.Errorf("unexpected files %s", files)
+	}
+	if _, err := os.Stat(ProjectLogsDir("bar")); !os.IsNotExist(err) {
+		t.Error("logs of removed project are kept")
+	}
+
+	// the latest log is kept even when it exceeds max bytes
+	LogMaxBytes = 50
+	err = CleanLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := logFileNames(t, "foo"); files != "20200101-000004-aaaaaa.log" {
+		t.Errorf("unexpected files %s", files)
+	}
+
+	// checkouts neither compress nor remove logs of deploys
+	f, err := CreateRunLog("foo", KindCheckout, "20200101-000005-bbbbbb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if files := logFileNames(t, "foo"); files != "20200101-000004-aaaaaa.log checkout" {
+		t.Errorf("unexpected files %s", files)
+	}
+	if ids, _ := RunIDs("foo", KindDeploy); len(ids) != 1 || ids[0] != "20200101-000004-aaaaaa" {
+		t.Errorf("unexpected run IDs %v", ids)
+	}
+	if path, _ := FindRunLog("foo", "20200101-000005-bbbbbb"); path != RunLogFile("foo", KindCheckout, "20200101-000005-bbbbbb") {
+		t.Errorf("unexpected path %s", path)
+	}
+}
+
+func TestCleanLogsGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Init(dir)
+	os.MkdirAll(ProjectDir("foo"), 0755)
+	os.MkdirAll(ProjectLogsDir("foo"), 0755)
+	LogMax, LogGzip = 20, true
+	defer func() { LogMax, LogGzip = 0, false }()
+
+	// logs written before -loggzip is turned on are compressed by the janitor
+	for _, id := range []string{"20200101-000000-aaaaaa", "20200101-000001-aaaaaa", "20200101-000002-aaaaaa"} {
+		ioutil.WriteFile(RunLogFile("foo", KindDeploy, id), []byte("log"), 0644)
+	}
+	err = CleanLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := logFileNames(t, "foo"); files != "20200101-000000-aaaaaa.log.gz 20200101-000001-aaaaaa.log.gz 20200101-000002-aaaaaa.log" {
+		t.Errorf("unexpected files %s", files)
+	}
+}
+
+func TestLogMaxBytesOfAllKinds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Init(dir)
+	os.MkdirAll(ProjectDir("foo"), 0755)
+	os.MkdirAll(RunLogsDir("foo", KindCheckout), 0755)
+	LogMax, LogMaxBytes = 20, 250
+	defer func() { LogMax, LogMaxBytes = 0, 0 }()
+
+	x := []byte(strings.Repeat("x", 100))
+	ioutil.WriteFile(RunLogFile("foo", KindDeploy, "20200101-000000-aaaaaa"), x, 0644)
+	ioutil.WriteFile(RunLogFile("foo", KindCheckout, "20200101-000001-aaaaaa"), x, 0644)
+	ioutil.WriteFile(RunLogFile("foo", KindDeploy, "20200101-000002-aaaaaa"), x, 0644)
+	ioutil.WriteFile(RunLogFile("foo", KindCheckout, "20200101-000003-aaaaaa"), x, 0644)
+	err = CleanLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := RunIDs("foo", KindDeploy, KindCheckout); strings.Join(ids, " ") != "20200101-000003-aaaaaa 20200101-000002-aaaaaa" {
+		t.Errorf("unexpected run IDs %v", ids)
+	}
+}
+
+func TestMigrateLegacyLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pploy-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir+"/logs", 0755)
+	ioutil.WriteFile(dir+"/logs/foo.log", []byte("latest"), 0644)
+	ioutil.WriteFile(dir+"/logs/foo.log.1.gz", []byte("previous"), 0644)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("JST", 9*60*60))
+	os.Chtimes(dir+"/logs/foo.log", mtime, mtime)
+	os.Chtimes(dir+"/logs/foo.log.1.gz", mtime, mtime)
+
+	Init(dir)
+	if files := logFileNames(t, "foo"); files != "20200101-180405-fffffe.log.gz 20200101-180405-ffffff.log" {
+		t.Errorf("unexpected files %s", files)
+	}
+	if ids, _ := RunIDs("foo", KindDeploy); len(ids) != 2 || ids[0] != "20200101-180405-ffffff" {
+		t.Errorf("unexpected run IDs %v", ids)
+	}
+}
+
+func logFileNames(t *testing.T, name Name) string {
+	files, err := ioutil.ReadDir(ProjectLogsDir(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,22 @@
+package workdir
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Name is a project name which is known to be a plain directory name,
+// so that paths built from it never point outside of the working directory
+type Name string
+
+// ParseName validates a project name given by users
+func ParseName(s string) (Name, error) {
+	if s == "" {
+		return "", errors.New("name is empty")
+	}
+	if s == "." || s == ".." || strings.ContainsAny(s, "/\\\x00") {
+		return "", errors.Errorf("invalid project name %q", s)
+	}
+	return Name(s), nil
+}
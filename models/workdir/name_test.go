@@ -0,0 +1,16 @@
+package workdir
+
+import "testing"
+
+func TestParseName(t *testing.T) {
+	for _, s := range []string{"foo", "foo.bar", "foo-bar_1", "..foo"} {
+		if _, err := ParseName(s); err != nil {
+			t.Errorf("expected %q to be valid, got %v", s, err)
+		}
+	}
+	for _, s := range []string{"", ".", "..", "../foo", "foo/bar", "foo\\bar", "/etc", "foo\x00"} {
+		if _, err := ParseName(s); err == nil {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package workdir
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
 	"sort"
@@ -11,15 +10,19 @@ import (
 
 var workDir string
 
-var LogMax int
-
 // Init sets an internal workDir variable and prepares the working directory
 func Init(dir string) {
 	workDir = dir
 
 	os.MkdirAll(WorkDir(), os.ModePerm)
 	os.MkdirAll(ProjectsDir(), os.ModePerm)
+	os.RemoveAll(workDir + "/cloning") // left by clones interrupted by a restart
+	os.MkdirAll(workDir+"/cloning", os.ModePerm)
 	os.MkdirAll(LogsDir(), os.ModePerm)
+	migrateLegacyLogs()
+	os.MkdirAll(MetaDir(), os.ModePerm)
+	os.MkdirAll(workDir+"/credentials", 0700)
+	os.MkdirAll(workDir+"/secrets", 0700)
 }
 
 // WorkDir returns the working directory
@@ -40,28 +43,63 @@ func LogsDir() string {
 	return workDir + "/logs"
 }
 
+// MetaDir returns the directory for project metadata
+func MetaDir() string {
+	assetInitialized()
+	return workDir + "/meta"
+}
+
+// CloningDir returns the directory a project is cloned into, which is moved to ProjectDir when the clone is done
+func CloningDir(name Name) string {
+	assetInitialized()
+	return workDir + "/cloning/" + string(name)
+}
+
 // ProjectDir returns the git repo directory for of a project
-func ProjectDir(name string) string {
-	return ProjectsDir() + "/" + name
+func ProjectDir(name Name) string {
+	return ProjectsDir() + "/" + string(name)
 }
 
-// LogFile returns the log file for of a project
-func LogFile(name string, generation int) string {
-	suffix := ""
-	if generation != 0 {
-		suffix = fmt.Sprintf(".%d", generation)
-	}
-	return LogsDir() + "/" + name + ".log" + suffix
+// MetaFile returns the metadata file of a project
+func MetaFile(name Name) string {
+	return MetaDir() + "/" + string(name) + ".json"
 }
 
-func RotateLogs(name string) error {
-	for i := LogMax; i > 0; i-- {
-		err := os.Rename(LogFile(name, i-1), LogFile(name, i))
-		if err != nil && !os.IsNotExist(err) {
-			return errors.Wrap(err, "failed to move log file")
-		}
+// CredentialsDir returns the directory for credentials of a project to access its git remote
+func CredentialsDir(name Name) string {
+	assetInitialized()
+	return workDir + "/credentials/" + string(name)
+}
+
+// SecretsFile returns the encrypted file of secrets of a project
+func SecretsFile(name Name) string {
+	assetInitialized()
+	return workDir + "/secrets/" + string(name) + ".enc"
+}
+
+// ProjectLogsDir returns the directory for log files of a project
+func ProjectLogsDir(name Name) string {
+	return LogsDir() + "/" + string(name)
+}
+
+// Kinds of runs, whose logs are kept and counted separately
+const (
+	KindDeploy   = "deploy"
+	KindCheckout = "checkout"
+)
+
+// RunLogsDir returns the directory for log files of runs of a kind. Logs of deploys are directly
+// under the directory of the project as written by older versions, and other kinds are in subdirectories.
+func RunLogsDir(name Name, kind string) string {
+	if kind == KindDeploy {
+		return ProjectLogsDir(name)
 	}
-	return nil
+	return ProjectLogsDir(name) + "/" + kind
+}
+
+// RunLogFile returns the log file of a run of a kind of a project
+func RunLogFile(name Name, kind string, runID string) string {
+	return RunLogsDir(name, kind) + "/" + runID + ".log"
 }
 
 func assetInitialized() {
@@ -71,34 +109,45 @@ func assetInitialized() {
 }
 
 // ProjectNames returns directory names under the working directory
-func ProjectNames() ([]string, error) {
+func ProjectNames() ([]Name, error) {
 	files, err := ioutil.ReadDir(ProjectsDir())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to list directory")
 	}
 
-	dirs := []string{}
+	dirs := []Name{}
 	for _, f := range files {
 		if f.IsDir() {
-			dirs = append(dirs, f.Name())
+			dirs = append(dirs, Name(f.Name()))
 		}
 	}
-	sort.Strings(dirs)
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i] < dirs[j]
+	})
 	return dirs, nil
 }
 
-// RemoveProjectFiles deletes project's git directory and log directory
-func RemoveProjectFiles(name string) error {
+// RemoveProjectFiles deletes project's git directory, metadata, credentials, secrets and log files
+func RemoveProjectFiles(name Name) error {
 	err := os.RemoveAll(ProjectDir(name))
 	if err != nil {
 		return errors.Wrap(err, "failed to delete project files")
 	}
 
-	for i := LogMax; i >= 0; i-- {
-		err = os.Remove(LogFile(name, i))
-		if err != nil && !os.IsNotExist(err) {
-			return errors.Wrap(err, "failed to delete log file")
-		}
+	err = os.Remove(MetaFile(name))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete metadata file")
+	}
+
+	err = os.RemoveAll(CredentialsDir(name))
+	if err != nil {
+		return errors.Wrap(err, "failed to delete credentials")
 	}
-	return nil
+
+	err = os.Remove(SecretsFile(name))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete secrets")
+	}
+
+	return removeLogs(name)
 }
@@ -1,27 +1,83 @@
 package unbuffered
 
-import "os/exec"
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
 
 // libc does not line buffer if output is not a terminal, instead use full buffering.
 // see: http://unix.stackexchange.com/questions/25372/turn-off-buffering-in-pipe
+//
+// Commands are run in one of the modes below.
+// stdbuf changes the buffering of libc, but does not help programs which buffer by themselves.
+// pty attaches stdout and stderr to a pseudo-terminal, so that programs line buffer as on a terminal.
+
+// Modes of running commands
+const (
+	ModeAuto   = "auto"   // stdbuf if installed, otherwise pty if supported, otherwise none
+	ModeStdbuf = "stdbuf" // wrap commands with stdbuf
+	ModePty    = "pty"    // attach commands to a pseudo-terminal
+	ModeNone   = "none"   // run commands as they are
+)
 
 var stdbuf string
 
+var mode string
+
 func init() {
-	err := exec.Command("which", "stdbuf").Run()
-	if err == nil {
-		stdbuf = "stdbuf"
-		return
+	for _, name := range []string{"stdbuf", "gstdbuf"} {
+		if _, err := exec.LookPath(name); err == nil {
+			stdbuf = name
+			break
+		}
+	}
+	mode = detectMode()
+}
+
+func detectMode() string {
+	if stdbuf != "" {
+		return ModeStdbuf
 	}
-	err = exec.Command("which", "gstdbuf").Run()
-	if err == nil {
-		stdbuf = "gstdbuf"
-		return
+	if ptySupported() {
+		return ModePty
 	}
-	panic("stdbuf not installed (for macOS, run `brew install coreutils`)")
+	return ModeNone
 }
 
-// Command takes a shell command and wraps it with either
-func Command(c string) *exec.Cmd {
-	return exec.Command(stdbuf, "-oL", "-eL", c)
+// SetMode sets how commands are run
+func SetMode(m string) error {
+	switch m {
+	case ModeAuto:
+		mode = detectMode()
+	case ModeStdbuf:
+		if stdbuf == "" {
+			return errors.New("stdbuf not installed (for macOS, run `brew install coreutils`)")
+		}
+		mode = m
+	case ModePty:
+		if !ptySupported() {
+			return errors.New("pseudo-terminals are not supported on this platform")
+		}
+		mode = m
+	case ModeNone:
+		mode = m
+	default:
+		return errors.Errorf("unknown mode %q (use auto, stdbuf, pty or none)", m)
+	}
+	return nil
+}
+
+// Mode returns how commands are run
+func Mode() string {
+	return mode
+}
+
+// Command takes a program and its arguments and wraps them with stdbuf in the stdbuf mode.
+// Use Start to run it, so that it is attached to a pseudo-terminal in the pty mode.
+func Command(name string, arg ...string) *exec.Cmd {
+	if mode == ModeStdbuf {
+		return exec.Command(stdbuf, append([]string{"-oL", "-eL", name}, arg...)...)
+	}
+	return exec.Command(name, arg...)
 }
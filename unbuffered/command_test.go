@@ -1,6 +1,7 @@
 package unbuffered
 
 import (
+	"io/ioutil"
 	"testing"
 )
 
@@ -14,3 +15,47 @@ func TestCommand(t *testing.T) {
 	}
 	//t.Log(string(out))
 }
+
+func TestStartPty(t *testing.T) {
+	if !ptySupported() {
+		t.Skip("pty is not supported")
+	}
+	defer SetMode(Mode())
+	err := SetMode(ModePty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := Command("sh", "-c", "test -t 1 && echo tty; echo err >&2")
+	out, err := Start(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(out.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	if string(b) != "tty\r\nerr\r\n" {
+		t.Errorf("unexpected output %q", b)
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	defer SetMode(Mode())
+	err := SetMode("foo")
+	if err == nil {
+		t.Error("expected error for an unknown mode")
+	}
+	err = SetMode(ModeNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := Command("ls", "-l").Args; len(args) != 2 || args[0] != "ls" {
+		t.Errorf("unexpected args %v", args)
+	}
+}
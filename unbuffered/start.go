@@ -0,0 +1,90 @@
+package unbuffered
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/pkg/errors"
+)
+
+// Output is the output of a command started by Start
+type Output struct {
+	Stdout io.ReadCloser
+	Stderr io.ReadCloser // nil in the pty mode, where stderr is written to Stdout
+}
+
+// Start starts cmd with its stdout and stderr connected to Output.
+// Output must be read to the end before calling cmd.Wait(), and closed after that.
+func Start(cmd *exec.Cmd) (*Output, error) {
+	if mode == ModePty {
+		return startPty(cmd)
+	}
+
+	// StdoutPipe returns a ReadCloser, but it's not meant to be Close()'ed by users before Wait
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get stdout pipe")
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get stderr pipe")
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &Output{Stdout: stdout, Stderr: stderr}, nil
+}
+
+func startPty(cmd *exec.Cmd) (*Output, error) {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open pty")
+	}
+	// stdin is left to the caller, so that answers written to it are not echoed back
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	err = cmd.Start()
+	// the child has its own copy, and reading master ends when all copies are closed
+	slave.Close()
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	return &Output{Stdout: &ptyReader{master}}, nil
+}
+
+// Close closes the output
+func (o *Output) Close() error {
+	err := o.Stdout.Close()
+	if o.Stderr != nil {
+		o.Stderr.Close()
+	}
+	return err
+}
+
+// ptyReader reads the master side of a pty, where EIO means that the slave side is closed
+type ptyReader struct {
+	*os.File
+}
+
+func (r *ptyReader) Read(b []byte) (int, error) {
+	n, err := r.File.Read(b)
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EIO {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func ptySupported() bool {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return false
+	}
+	master.Close()
+	slave.Close()
+	return true
+}
@@ -1,18 +1,21 @@
 package web
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/edvakf/go-pploy/models/cache"
+	"github.com/edvakf/go-pploy/models/credentials"
 	"github.com/edvakf/go-pploy/models/gitutil"
+	"github.com/edvakf/go-pploy/models/jobs"
 	"github.com/edvakf/go-pploy/models/ldapusers"
 	"github.com/edvakf/go-pploy/models/locks"
 	"github.com/edvakf/go-pploy/models/project"
+	"github.com/edvakf/go-pploy/models/secrets"
 	"github.com/edvakf/go-pploy/models/workdir"
 	"github.com/fukata/golang-stats-api-handler"
 	"github.com/labstack/echo"
@@ -37,8 +40,12 @@ func messageJSON(c echo.Context, message string) error {
 }
 
 func getStatusAPI(c echo.Context) error {
-	// p is nil when project not found
-	p, _ := project.Full(c.Param("project"))
+	// p is nil when project not found or its config is broken
+	message := ReadFlashCookie(c)
+	p, err := project.Full(c.Param("project"))
+	if err != nil && c.Param("project") != "" && message == "" {
+		message = err.Error()
+	}
 
 	all, err := project.All()
 	if err != nil {
@@ -57,7 +64,7 @@ func getStatusAPI(c echo.Context) error {
 		AllUsers       []string          `json:"allUsers"`
 		CurrentUser    *string           `json:"currentUser"`
 	}{
-		Message:        ReadFlashCookie(c),
+		Message:        message,
 		AllProjects:    all,
 		CurrentProject: p,
 		AllUsers:       users,
@@ -71,17 +78,120 @@ func getCommitsAPI(c echo.Context) error {
 		return messageJSON(c, err.Error())
 	}
 
-	commits, err := gitutil.RecentCommits(workdir.ProjectDir(p.Name))
+	form := new(struct {
+		Ref    string `query:"ref"`
+		Cursor int    `query:"cursor" validate:"min=0"` // same as offset, returned as nextCursor
+		Offset int    `query:"offset" validate:"min=0"`
+		Page   int    `query:"page" validate:"min=0"` // 1-origin
+		Limit  int    `query:"limit" validate:"min=0,max=100"`
+		Author string `query:"author"`
+		Path   string `query:"path"`
+		Since  string `query:"since"`
+		Until  string `query:"until"`
+	})
+	err = validateForm(c, form)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	opts := gitutil.LogOptions{
+		Ref:    form.Ref,
+		Limit:  form.Limit,
+		Author: form.Author,
+		Path:   form.Path,
+	}
+	if opts.Limit == 0 {
+		opts.Limit = gitutil.DefaultLogLimit
+	}
+	if form.Cursor != 0 {
+		opts.Skip = form.Cursor
+	} else if form.Offset != 0 {
+		opts.Skip = form.Offset
+	} else if form.Page > 1 {
+		opts.Skip = (form.Page - 1) * opts.Limit
+	}
+	opts.Since, err = parseTimeParam(form.Since)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+	opts.Until, err = parseTimeParam(form.Until)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	commits, next, err := gitutil.Log(workdir.ProjectDir(p.Name), opts)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	nextCursor := ""
+	if next != 0 {
+		nextCursor = strconv.Itoa(next)
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Commits    []gitutil.Commit `json:"commits"`
+		NextCursor string           `json:"nextCursor"`
+	}{
+		Commits:    commits,
+		NextCursor: nextCursor,
+	})
+}
+
+// parseTimeParam accepts either RFC3339 or YYYY-MM-DD, and an empty string as the zero time
+func parseTimeParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	t, err = time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (use RFC3339 or YYYY-MM-DD)", s)
+	}
+	return t, nil
+}
+
+func getRefsAPI(c echo.Context) error {
+	p, err := project.FromName(c.Param("project"))
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	refs, err := gitutil.ListRefs(workdir.ProjectDir(p.Name))
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, refs)
+}
+
+func getDiffAPI(c echo.Context) error {
+	p, err := project.FromName(c.Param("project"))
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	diff, err := gitutil.CommitDiff(workdir.ProjectDir(p.Name), c.Param("commit"))
 	if err != nil {
 		return messageJSON(c, err.Error())
 	}
 
-	return c.JSON(http.StatusOK, commits)
+	return c.JSON(http.StatusOK, diff)
 }
 
 func createProject(c echo.Context) error {
 	form := new(struct {
-		URL string `form:"url" validate:"required"`
+		URL        string `form:"url" validate:"required"`
+		Name       string `form:"name"`
+		Depth      string `form:"depth" validate:"omitempty,eq=full|number"` // number rejects negative values
+		Branch     string `form:"branch"`
+		Submodules string `form:"submodules" validate:"omitempty,eq=on|eq=off"`
+		SSHKey     string `form:"sshKey"`
+		Username   string `form:"username"`
+		Token      string `form:"token"`
 	})
 	err := validateForm(c, form)
 	if err != nil {
@@ -89,13 +199,90 @@ func createProject(c echo.Context) error {
 		return c.Redirect(http.StatusFound, PathPrefix)
 	}
 
-	p, err := project.Clone(form.URL)
+	opts := project.DefaultCloneOptions
+	if form.Depth == "full" {
+		opts.Depth = 0
+	} else if form.Depth != "" {
+		opts.Depth, _ = strconv.Atoi(form.Depth)
+	}
+	opts.Branch = form.Branch
+	if form.Submodules == "off" {
+		opts.Submodules = false
+	}
+	cred := credentials.Credential{
+		SSHKey:   form.SSHKey,
+		Username: form.Username,
+		Token:    form.Token,
+	}
+
+	job, err := project.Clone(form.URL, form.Name, opts, cred)
 	if err != nil {
 		WriteFlashCookie(c, err.Error())
 		return c.Redirect(http.StatusFound, PathPrefix)
 	}
 
-	return c.Redirect(http.StatusFound, PathPrefix+p.Name)
+	// the welcome page follows the job and moves to the project when it's done
+	return c.Redirect(http.StatusFound, PathPrefix+"?job="+job.Info().ID)
+}
+
+func getJobAPI(c echo.Context) error {
+	job := jobs.Get(c.Param("id"))
+	if job == nil {
+		return messageJSON(c, "job not found")
+	}
+
+	return c.JSON(http.StatusOK, job.Info())
+}
+
+func getJobLogs(c echo.Context) error {
+	job := jobs.Get(c.Param("id"))
+	if job == nil {
+		return c.String(http.StatusOK, "job not found")
+	}
+
+	return transferEncodingChunked(c, job.NewReader())
+}
+
+func postCredentials(c echo.Context) error {
+	p, err := project.FromName(c.Param("project"))
+	if err != nil {
+		WriteFlashCookie(c, err.Error())
+		return c.Redirect(http.StatusFound, PathPrefix)
+	}
+
+	user := currentUser(c)
+	if user == nil {
+		WriteFlashCookie(c, "user cookie not set")
+		return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
+	}
+	if l := locks.Check(string(p.Name), time.Now()); l == nil || l.User != *user {
+		WriteFlashCookie(c, "updating credentials requires holding the lock")
+		return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
+	}
+
+	form := new(struct {
+		SSHKey   string `form:"sshKey"`
+		Username string `form:"username"`
+		Token    string `form:"token"`
+	})
+	err = validateForm(c, form)
+	if err != nil {
+		WriteFlashCookie(c, err.Error())
+		return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
+	}
+
+	// empty form removes the credential
+	err = credentials.Save(p.Name, credentials.Credential{
+		SSHKey:   form.SSHKey,
+		Username: form.Username,
+		Token:    form.Token,
+	})
+	if err != nil {
+		WriteFlashCookie(c, err.Error())
+		return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
+	}
+
+	return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
 }
 
 func getLogs(c echo.Context) error {
@@ -104,25 +291,140 @@ func getLogs(c echo.Context) error {
 		return c.String(http.StatusOK, err.Error())
 	}
 
-	generation, err := strconv.Atoi(c.QueryParam("generation"))
+	// the generation query parameter of older links counts deploys back from the latest one
+	runID := c.Param("run")
+	if runID == "" {
+		generation, err := strconv.Atoi(c.QueryParam("generation"))
+		if err != nil {
+			generation = 0
+		}
+		runID, err = p.RunIDOfGeneration(generation)
+		if err != nil {
+			return err
+		}
+	}
+
+	timestamps := c.QueryParam("timestamps") == "1"
+
+	if c.Request().Header.Get("Range") != "" {
+		content, err := p.LogContent(runID, timestamps)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+		http.ServeContent(c.Response(), c.Request(), "", content.ModTime, content)
+		return nil
+	}
+
+	tail, err := strconv.Atoi(c.QueryParam("tail"))
 	if err != nil {
-		generation = 0
+		tail = 0
+	}
+	opts := project.LogOptions{
+		Full:       c.QueryParam("full") == "1",
+		Tail:       tail,
+		Follow:     c.QueryParam("follow") == "1",
+		Timestamps: timestamps,
 	}
 
-	r, err := p.LogReader(c.QueryParam("full") == "1", generation)
+	r, err := p.LogReader(runID, opts)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
+	if opts.Follow {
+		return transferEncodingChunked(c, r)
+	}
+	c.Response().Header().Set("Accept-Ranges", "bytes")
 	return c.Stream(http.StatusOK, echo.MIMETextPlainCharsetUTF8, r)
 }
 
+func getLogsAPI(c echo.Context) error {
+	p, err := project.FromName(c.Param("project"))
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	ids, err := p.RunIDs(project.RunDeploy, project.RunCheckout)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+	logs := []project.LogInfo{}
+	for _, id := range ids {
+		info, err := p.LogInfo(id)
+		if err != nil {
+			return messageJSON(c, err.Error())
+		}
+		if info != nil {
+			logs = append(logs, *info)
+		}
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Logs []project.LogInfo `json:"logs"`
+	}{
+		Logs: logs,
+	})
+}
+
+func getSearchAPI(c echo.Context) error {
+	form := new(struct {
+		Query   string `query:"q" validate:"required"`
+		Regexp  string `query:"regexp"`
+		Project string `query:"project"` // all projects when empty
+		Context int    `query:"context"`
+		Limit   int    `query:"limit"`
+	})
+	err := validateForm(c, form)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	var names []workdir.Name
+	if form.Project != "" {
+		p, err := project.FromName(form.Project)
+		if err != nil {
+			return messageJSON(c, err.Error())
+		}
+		names = []workdir.Name{p.Name}
+	} else {
+		names, err = workdir.ProjectNames()
+		if err != nil {
+			return messageJSON(c, err.Error())
+		}
+	}
+
+	matches, truncated, err := project.SearchLogs(names, project.SearchOptions{
+		Query:   form.Query,
+		Regexp:  form.Regexp == "1",
+		Context: form.Context,
+		Limit:   form.Limit,
+	})
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Matches   []project.LogMatch `json:"matches"`
+		Truncated bool               `json:"truncated"`
+	}{
+		Matches:   matches,
+		Truncated: truncated,
+	})
+}
+
 func postCheckout(c echo.Context) error {
 	p, err := project.FromName(c.Param("project"))
 	if err != nil {
 		return c.String(http.StatusOK, err.Error())
 	}
 
+	user := currentUser(c)
+	if user == nil {
+		return c.String(http.StatusOK, "user cookie not set")
+	}
+
 	form := new(struct {
 		Ref string `form:"ref" validate:"required"`
 	})
@@ -131,15 +433,21 @@ func postCheckout(c echo.Context) error {
 		return c.String(http.StatusOK, err.Error())
 	}
 
-	r, err := p.Checkout(form.Ref)
+	err = gitutil.ValidateRef(workdir.ProjectDir(p.Name), form.Ref)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	r, err := p.Checkout(form.Ref, *user)
 	if err != nil {
 		return c.String(http.StatusOK, err.Error())
 	}
+	defer r.Close()
 
 	// Update default branch in cache
 	defaultBranch, err := p.GetDefaultBranch()
 	if err == nil {
-		cache.DefaultBranch.Store(p.Name, defaultBranch)
+		cache.DefaultBranch.Store(string(p.Name), defaultBranch)
 	}
 
 	return transferEncodingChunked(c, r)
@@ -164,14 +472,125 @@ func postDeploy(c echo.Context) error {
 		return c.String(http.StatusOK, err.Error())
 	}
 
-	r, err := p.Deploy(form.Target, *user)
+	// deploy params are sent as param.<name>. the last value wins, so that
+	// a hidden input followed by a checkbox gives "0" or "1".
+	values := map[string]string{}
+	formParams, err := c.FormParams()
 	if err != nil {
 		return c.String(http.StatusOK, err.Error())
 	}
+	for k, v := range formParams {
+		if strings.HasPrefix(k, "param.") && len(v) != 0 {
+			values[strings.TrimPrefix(k, "param.")] = v[len(v)-1]
+		}
+	}
+
+	r, err := p.Deploy(form.Target, *user, values)
+	if err != nil {
+		return c.String(http.StatusOK, err.Error())
+	}
+	defer r.Close()
 
 	return transferEncodingChunked(c, r)
 }
 
+func getPromptAPI(c echo.Context) error {
+	p, err := project.FromName(c.Param("project"))
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Prompt *project.Prompt `json:"prompt"`
+	}{
+		Prompt: p.CurrentPrompt(),
+	})
+}
+
+func postPromptAPI(c echo.Context) error {
+	p, err := project.FromName(c.Param("project"))
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	user := currentUser(c)
+	lock := locks.Check(string(p.Name), time.Now())
+	if user == nil || lock == nil || lock.User != *user {
+		return messageJSON(c, "only the lock holder can answer")
+	}
+
+	form := new(struct {
+		ID     int    `form:"id" validate:"required"`
+		Answer string `form:"answer"`
+	})
+	err = validateForm(c, form)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	err = p.AnswerPrompt(form.ID, form.Answer)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	return messageJSON(c, "")
+}
+
+func getSecretsAPI(c echo.Context) error {
+	p, err := project.FromName(c.Param("project"))
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	if !isAdmin(c) {
+		return messageJSON(c, "only admins can manage secrets")
+	}
+
+	list, err := secrets.List(p.Name)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Secrets []secrets.Secret `json:"secrets"`
+	}{
+		Secrets: list,
+	})
+}
+
+func postSecretsAPI(c echo.Context) error {
+	p, err := project.FromName(c.Param("project"))
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	if !isAdmin(c) {
+		return messageJSON(c, "only admins can manage secrets")
+	}
+
+	form := new(struct {
+		Env   string `form:"env"` // empty for all environments
+		Name  string `form:"name" validate:"required"`
+		Value string `form:"value"`
+	})
+	err = validateForm(c, form)
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	// empty value deletes the secret
+	if form.Value == "" {
+		err = secrets.Delete(p.Name, form.Env, form.Name)
+	} else {
+		err = secrets.Set(p.Name, form.Env, form.Name, form.Value)
+	}
+	if err != nil {
+		return messageJSON(c, err.Error())
+	}
+
+	return messageJSON(c, "")
+}
+
 func postRemove(c echo.Context) error {
 	p, err := project.FromName(c.Param("project"))
 	if err != nil {
@@ -183,7 +602,7 @@ func postRemove(c echo.Context) error {
 		return c.String(http.StatusOK, err.Error())
 	}
 
-	cache.DefaultBranch.Delete(p.Name)
+	cache.DefaultBranch.Delete(string(p.Name))
 
 	return c.Redirect(http.StatusFound, PathPrefix)
 }
@@ -194,17 +613,24 @@ func transferEncodingChunked(c echo.Context, r io.Reader) error {
 	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
 	c.Response().WriteHeader(http.StatusOK)
 
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		c.Response().Write([]byte(scanner.Text() + "\n"))
-		c.Response().Flush()
-	}
+	// returns when the client disconnects, so that callers can close r to stop its writer
+	_, err := io.Copy(flushWriter{c.Response()}, r)
+	return err
+}
 
-	if err := scanner.Err(); err != nil {
-		return err
-	}
+// flushWriter flushes the response after each write, so that output is sent to the client as it comes
+type flushWriter struct {
+	res *echo.Response
+}
 
-	return nil
+// Write implements the io.Writer interface
+func (fw flushWriter) Write(b []byte) (int, error) {
+	n, err := fw.res.Write(b)
+	if err != nil {
+		return n, err
+	}
+	fw.res.Flush()
+	return n, nil
 }
 
 func redirectToProject(c echo.Context) error {
@@ -213,7 +639,7 @@ func redirectToProject(c echo.Context) error {
 		WriteFlashCookie(c, err.Error())
 		return c.Redirect(http.StatusFound, PathPrefix)
 	}
-	return c.Redirect(http.StatusFound, PathPrefix+p.Name)
+	return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
 }
 
 func postLock(c echo.Context) error {
@@ -230,26 +656,26 @@ func postLock(c echo.Context) error {
 	err = validateForm(c, form)
 	if err != nil {
 		WriteFlashCookie(c, err.Error())
-		return c.Redirect(http.StatusFound, PathPrefix+p.Name)
+		return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
 	}
 
 	if form.Operation == "gain" {
-		_, err := locks.Gain(p.Name, form.User, time.Now())
+		_, err := locks.Gain(string(p.Name), form.User, time.Now())
 		if err != nil {
 			WriteFlashCookie(c, err.Error())
-			return c.Redirect(http.StatusFound, PathPrefix+p.Name)
+			return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
 		}
 	} else if form.Operation == "release" {
-		err := locks.Release(p.Name, form.User, time.Now())
+		err := locks.Release(string(p.Name), form.User, time.Now())
 		if err != nil {
 			WriteFlashCookie(c, err.Error())
-			return c.Redirect(http.StatusFound, PathPrefix+p.Name)
+			return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
 		}
 	} else if form.Operation == "extend" {
-		_, err := locks.Extend(p.Name, form.User, time.Now())
+		_, err := locks.Extend(string(p.Name), form.User, time.Now())
 		if err != nil {
 			WriteFlashCookie(c, err.Error())
-			return c.Redirect(http.StatusFound, PathPrefix+p.Name)
+			return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
 		}
 	} else {
 		panic("should not reach here")
@@ -257,7 +683,7 @@ func postLock(c echo.Context) error {
 
 	WriteUserCookie(c, form.User)
 
-	return c.Redirect(http.StatusFound, PathPrefix+p.Name)
+	return c.Redirect(http.StatusFound, PathPrefix+string(p.Name))
 }
 
 func validateForm(c echo.Context, form interface{}) error {
@@ -279,15 +705,27 @@ func Server() {
 	e.Validator = &Validator
 
 	e.POST(PathPrefix+"_create", createProject)
+	e.GET(PathPrefix+"_jobs/:id/logs", getJobLogs)
+	e.GET(PathPrefix+"api/jobs/:id", getJobAPI)
 	e.GET(PathPrefix+"api/status/", getStatusAPI)
 	e.GET(PathPrefix+"api/status/:project", getStatusAPI)
 	e.GET(PathPrefix+"api/commits/:project", getCommitsAPI)
+	e.GET(PathPrefix+"api/refs/:project", getRefsAPI)
+	e.GET(PathPrefix+"api/diff/:project/:commit", getDiffAPI)
+	e.GET(PathPrefix+"api/prompt/:project", getPromptAPI)
+	e.POST(PathPrefix+"api/prompt/:project", postPromptAPI)
+	e.GET(PathPrefix+"api/secrets/:project", getSecretsAPI)
+	e.GET(PathPrefix+"api/logs/:project", getLogsAPI)
+	e.GET(PathPrefix+"api/search", getSearchAPI)
+	e.POST(PathPrefix+"api/secrets/:project", postSecretsAPI)
 	e.POST(PathPrefix+":project/lock", postLock)
 	e.GET(PathPrefix+":project/lock", redirectToProject)
 	e.GET(PathPrefix+":project/logs", getLogs)
+	e.GET(PathPrefix+":project/logs/:run", getLogs)
 	e.POST(PathPrefix+":project/checkout", postCheckout)
 	e.POST(PathPrefix+":project/deploy", postDeploy)
 	e.POST(PathPrefix+":project/remove", postRemove)
+	e.POST(PathPrefix+":project/credentials", postCredentials)
 	e.GET(PathPrefix+"assets/*", echo.WrapHandler(http.StripPrefix(PathPrefix, http.FileServer(Assets))))
 	e.GET(PathPrefix+"api/_stats", echo.WrapHandler(http.HandlerFunc(stats_api.Handler)))
 	e.GET(PathPrefix+":project", getIndex) // rewrite middlewareでできそう
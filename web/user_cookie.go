@@ -1,7 +1,9 @@
 package web
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo"
@@ -33,3 +35,31 @@ func currentUser(c echo.Context) *string {
 	}
 	return &u
 }
+
+// Admins are users who can manage secrets
+var Admins []string
+
+// AdminToken must be sent by admins as a bearer token, since the user cookie can be set by anyone.
+// Secrets cannot be managed when it is empty.
+var AdminToken string
+
+// isAdmin returns true when the user of the request is an admin and the request has the admin token
+func isAdmin(c echo.Context) bool {
+	if AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+		return false
+	}
+	user := currentUser(c)
+	if user == nil {
+		return false
+	}
+	for _, a := range Admins {
+		if a == *user {
+			return true
+		}
+	}
+	return false
+}